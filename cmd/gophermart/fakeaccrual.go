@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/hrapovd1/loyalty-account/internal/config"
+	"github.com/hrapovd1/loyalty-account/internal/fakeaccrual"
+	"github.com/hrapovd1/loyalty-account/internal/logging"
+)
+
+const fakeAccrualUsage = "Usage: gophermart fake-accrual [-r address] [script.json]"
+
+// fakeAccrual runs "gophermart fake-accrual" command, it serves fake
+// accrual system on accrual system address by script from file or
+// by default script.
+func fakeAccrual(appConf *config.Config, logger *slog.Logger, args []string) {
+	if len(args) > 1 {
+		fatal(logger, fakeAccrualUsage)
+	}
+
+	script := fakeaccrual.DefaultScript
+	if len(args) == 1 {
+		var err error
+		if script, err = fakeaccrual.LoadScript(args[0]); err != nil {
+			fatal(logger, "can't load script", logging.Err(err))
+		}
+	}
+
+	// Адрес системы начисления может быть задан вместе со схемой.
+	address := appConf.AccrualAddress
+	if i := strings.Index(address, "://"); i >= 0 {
+		address = address[i+3:]
+	}
+	address = strings.TrimSuffix(address, "/")
+
+	logger.Info("fake accrual system is waiting connections", "address", address)
+	err := http.ListenAndServe(address, fakeaccrual.NewServer(script))
+	fatal(logger, "fake accrual system stopped", logging.Err(err))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hrapovd1/loyalty-account/internal/config"
+	"github.com/hrapovd1/loyalty-account/internal/dbstorage"
+	"github.com/hrapovd1/loyalty-account/internal/logging"
+)
+
+const ledgerUsage = "Usage: gophermart ledger [-d dsn] check"
+
+// ledger runs "gophermart ledger" command, check exits with code 1
+// when discrepancies are found.
+func ledger(appConf *config.Config, logger *slog.Logger, args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		fatal(logger, ledgerUsage)
+	}
+
+	store, err := dbstorage.NewDB(appConf.DatabaseDSN, logger)
+	if err != nil {
+		fatal(logger, "can't open db", logging.Err(err))
+	}
+	defer store.Close()
+
+	discrepancies, err := store.CheckLedger(context.Background())
+	if err != nil {
+		fatal(logger, "ledger check failed", logging.Err(err))
+	}
+	if len(discrepancies) == 0 {
+		logger.Info("ledger is consistent")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tLOGIN\tTRANSACTION\tEXPECTED\tACTUAL")
+	for _, d := range discrepancies {
+		account, transaction := "-", "-"
+		if d.AccountID != 0 {
+			account = fmt.Sprint(d.AccountID)
+		}
+		if d.TransactionID != 0 {
+			transaction = fmt.Sprint(d.TransactionID)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", account, d.Account, transaction, d.Expected, d.Actual)
+	}
+	w.Flush()
+	store.Close()
+	fatal(logger, "ledger discrepancies found", "count", len(discrepancies))
+}
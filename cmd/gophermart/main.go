@@ -2,49 +2,213 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/hrapovd1/loyalty-account/internal/auth"
 	"github.com/hrapovd1/loyalty-account/internal/config"
+	"github.com/hrapovd1/loyalty-account/internal/dbstorage"
 	"github.com/hrapovd1/loyalty-account/internal/dispatcher"
 	"github.com/hrapovd1/loyalty-account/internal/handlers"
+	"github.com/hrapovd1/loyalty-account/internal/health"
+	"github.com/hrapovd1/loyalty-account/internal/logging"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/tracing"
 )
 
 func main() {
-	logger := log.New(os.Stdout, "GOPHERMART:\t", log.Ldate|log.Ltime)
+	// До чтения конфигурации пишутся только ошибки запуска.
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	// Первый аргумент без дефиса является командой, по умолчанию запускается сервер.
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
 
 	// Чтение флагов и установка конфигурации
-	appConf, err := config.NewAppConf(config.GetAppFlags())
+	appConf, err := config.NewAppConf(config.GetAppFlags(args))
 	if err != nil {
-		logger.Fatalln(err)
+		fatal(logger, "invalid configuration", logging.Err(err))
+	}
+	if logger, err = logging.New(os.Stdout, appConf.LogLevel); err != nil {
+		fatal(slog.Default(), "invalid configuration", logging.Err(err))
+	}
+	slog.SetDefault(logger)
+
+	switch command {
+	case "serve":
+		if err := serve(appConf, logger); err != nil {
+			fatal(logger, "shutdown is not clean", logging.Err(err))
+		}
+	case "migrate":
+		migrate(appConf, logger, flag.Args())
+	case "ledger":
+		ledger(appConf, logger, flag.Args())
+	case "fake-accrual":
+		fakeAccrual(appConf, logger, flag.Args())
+	default:
+		fatal(logger, "unknown command, available commands: serve, migrate, ledger, fake-accrual", "command", command)
 	}
+}
+
+// fatal logs error message and exits with code 1.
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// serve runs application until SIGINT or SIGTERM. On signal server stops
+// accepting connections and waits active requests, dispatcher finishes
+// started updates, and db connections are closed last. Not nil error
+// means that shutdown was not clean.
+func serve(appConf *config.Config, logger *slog.Logger) (err error) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Трассировка завершается последней, чтобы выгрузить спаны остановки.
+	shutdownTracing, err := tracing.Setup(ctx, appConf.TracesExporter, os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), appConf.ShutdownTimeout)
+		defer flushCancel()
+		if flushErr := shutdownTracing(flushCtx); flushErr != nil {
+			logger.Error("can't flush traces", logging.Err(flushErr))
+		}
+	}()
 
 	// Настройка подключения к БД и создание приложения.
-	app, err := handlers.NewAppHandler(*appConf, logger)
+	store, err := dbstorage.NewDB(appConf.DatabaseDSN, logger)
 	if err != nil {
-		logger.Fatalln(err)
+		return err
 	}
-	defer app.Storage.Close()
+	// Соединения с БД закрываются последними, после остановки сервера и диспетчера.
+	defer func() {
+		if closeErr := store.Close(); closeErr != nil {
+			logger.Error("can't close db", logging.Err(closeErr))
+			if err == nil {
+				err = closeErr
+			}
+		}
+	}()
 
-	if err := app.Storage.InitDB(); err != nil {
-		logger.Fatalln(err)
+	if err = store.InitDB(); err != nil {
+		return err
+	}
+
+	app, err := handlers.NewAppHandler(*appConf, &store, logger)
+	if err != nil {
+		return err
+	}
+
+	// Назначение администраторов из конфигурации
+	if err = grantAdmins(ctx, app.Storage, appConf.AdminLogins, logger); err != nil {
+		return err
 	}
 
 	// Запуск диспетчера системы расчета баллов
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	dsptchrCtx, dsptchrCancel := context.WithCancel(context.Background())
+	defer dsptchrCancel()
 
+	accrualClient := dispatcher.NewHTTPAccrualClient(appConf.AccrualAddress)
 	dsptchr := dispatcher.Dispatcher{
 		Storage:        app.Storage,
 		Logger:         logger,
 		AccrualAddress: appConf.AccrualAddress,
+		Client:         accrualClient,
+		Workers:        appConf.AccrualWorkers,
+		PollInterval:   appConf.AccrualPollInterval,
+		RequestTimeout: appConf.AccrualRequestTimeout,
+		BatchSize:      appConf.AccrualBatchSize,
+		Lease:          appConf.AccrualLease,
+		InstanceID:     appConf.InstanceID,
+		BackoffMin:     appConf.AccrualBackoffMin,
+		BackoffMax:     appConf.AccrualBackoffMax,
+		MaxAge:         appConf.AccrualMaxAge,
 	}
 
-	go dsptchr.Run(ctx)
+	// Готовность зависит от системы начисления и работы диспетчера.
+	app.Readiness.Add("accrual", health.Cached(accrualClient.Ping, appConf.AccrualHealthTTL))
+	app.Readiness.Add("dispatcher", health.Heartbeat(dsptchr.Heartbeat, appConf.HeartbeatWindow))
+
+	dsptchrDone := make(chan struct{})
+	go func() {
+		defer close(dsptchrDone)
+		dsptchr.Run(dsptchrCtx)
+	}()
 
 	// Открытие порта и обслуживание API запросов
-	router := handlers.NewRouter(app)
-	logger.Println("App is waiting connections on: ", appConf.AppAddress)
-	logger.Fatal(http.ListenAndServe(appConf.AppAddress, router))
+	server := &http.Server{
+		Addr:    appConf.AppAddress,
+		Handler: handlers.NewRouter(app),
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("app is waiting connections", "address", appConf.AppAddress)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serverErr:
+		// сервер не запустился или упал, диспетчер останавливается так же
+		logger.Error("server stopped", logging.Err(err))
+	case <-ctx.Done():
+		logger.Info("shutdown signal received", "timeout", appConf.ShutdownTimeout.String())
+	}
+	stop()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), appConf.ShutdownTimeout)
+	defer shutdownCancel()
+
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+		logger.Error("server shutdown failed", logging.Err(shutdownErr))
+		if err == nil {
+			err = shutdownErr
+		}
+	}
+	dsptchrCancel()
+	select {
+	case <-dsptchrDone:
+	case <-shutdownCtx.Done():
+		logger.Error("dispatcher is not stopped in shutdown timeout")
+		if err == nil {
+			err = shutdownCtx.Err()
+		}
+	}
+	if err == nil {
+		logger.Info("shutdown is complete")
+	}
+	return err
+}
+
+// grantAdmins sets admin role to users with logins. Logins must be
+// registered already, otherwise anyone could register configured login
+// and get admin rights, so unknown login fails startup.
+func grantAdmins(ctx context.Context, store storage.Storage, logins []string, logger *slog.Logger) error {
+	for _, login := range logins {
+		user, err := store.GetUser(ctx, login)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("admin login %q is not registered: %w", login, err)
+		}
+		if err != nil {
+			return err
+		}
+		if user.Role == auth.RoleAdmin {
+			continue
+		}
+		if err := store.SetUserRole(ctx, login, auth.RoleAdmin); err != nil {
+			return fmt.Errorf("can't grant admin role to %q: %w", login, err)
+		}
+		logger.Info("admin role granted", "login", login)
+	}
+	return nil
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hrapovd1/loyalty-account/internal/config"
+	"github.com/hrapovd1/loyalty-account/internal/dbstorage"
+	"github.com/hrapovd1/loyalty-account/internal/logging"
+)
+
+const migrateUsage = "Usage: gophermart migrate [-d dsn] up|down [N]|status"
+
+// migrate runs "gophermart migrate" command.
+func migrate(appConf *config.Config, logger *slog.Logger, args []string) {
+	if len(args) == 0 {
+		fatal(logger, migrateUsage)
+	}
+
+	store, err := dbstorage.NewDB(appConf.DatabaseDSN, logger)
+	if err != nil {
+		fatal(logger, "can't open db", logging.Err(err))
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		done, err := store.MigrateUp(ctx)
+		for _, m := range done {
+			logger.Info("applied migration", "version", m.Version, "name", m.Name)
+		}
+		if err != nil {
+			fatal(logger, "migration failed", logging.Err(err))
+		}
+		if len(done) == 0 {
+			logger.Info("no pending migrations")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fatal(logger, migrateUsage)
+			}
+		}
+		done, err := store.MigrateDown(ctx, steps)
+		for _, m := range done {
+			logger.Info("rolled back migration", "version", m.Version, "name", m.Name)
+		}
+		if err != nil {
+			fatal(logger, "migration failed", logging.Err(err))
+		}
+	case "status":
+		states, err := store.MigrationStatus(ctx)
+		if err != nil {
+			fatal(logger, "migration failed", logging.Err(err))
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range states {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		w.Flush()
+	default:
+		fatal(logger, migrateUsage)
+	}
+}
@@ -2,75 +2,72 @@ package auth
 
 import (
 	"context"
-	"crypto/sha1"
-	"fmt"
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 
-	"github.com/hrapovd1/loyalty-account/internal/dbstorage"
 	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
 	"github.com/hrapovd1/loyalty-account/internal/types"
 )
 
 const (
-	hashSalt       = "sd!oJFDw4-3409sdf."
-	expireDuration = 30 * time.Minute
-	signingKey     = "r'tyJFSdf384SLD.jsdf"
+	expireDuration  = 30 * time.Minute
+	refreshDuration = 30 * 24 * time.Hour
 )
 
-func CreateUser(ctx context.Context, storage *dbstorage.DBStorage, user models.User) error {
-	pwdHash := sha1.New()
-	pwdHash.Write([]byte(user.Password))
-	pwdHash.Write([]byte(hashSalt))
-	user.Password = fmt.Sprintf("%x", pwdHash.Sum(nil))
+func CreateUser(ctx context.Context, store storage.Storage, hasher PasswordHasher, user models.User) error {
+	pwdHash, err := hasher.Hash(user.Password)
+	if err != nil {
+		return err
+	}
+	user.Password = pwdHash
 
-	return storage.CreateUser(ctx, user)
+	return store.CreateUser(ctx, user)
 }
 
-func GetToken(ctx context.Context, storage *dbstorage.DBStorage, user models.User) (string, error) {
-	pwdHash := sha1.New()
-	pwdHash.Write([]byte(user.Password))
-	pwdHash.Write([]byte(hashSalt))
-	user.Password = fmt.Sprintf("%x", pwdHash.Sum(nil))
-	dbUser, err := storage.GetUser(ctx, user.Login)
+// GetToken checks user password and opens new session for client.
+func GetToken(ctx context.Context, store storage.Storage, hasher PasswordHasher, keys *Keyring, user models.User, client types.ClientInfo) (*types.LoginResponse, error) {
+	dbUser, err := store.GetUser(ctx, user.Login)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, storage.ErrInvalidLoginPassword
+		}
+		return nil, err
+	}
+	ok, needsRehash, err := VerifyPassword(hasher, user.Password, dbUser.Password)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	if dbUser.Password != user.Password {
-		return "", dbstorage.ErrInvalidLoginPassword
+	if !ok {
+		return nil, storage.ErrInvalidLoginPassword
 	}
-	token := jwt.NewWithClaims(
-		jwt.SigningMethodHS256,
-		&types.Claims{
-			Login: user.Login,
-			RegisteredClaims: jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(time.Now().Add(expireDuration)),
-				IssuedAt:  jwt.NewNumericDate(time.Now()),
-			},
-		})
-
-	return token.SignedString([]byte(signingKey))
+	// Пароль верный, поэтому можно заменить устаревший хеш на хеш текущего алгоритма.
+	if needsRehash {
+		if pwdHash, err := hasher.Hash(user.Password); err == nil {
+			// Ошибка не критична, хеш будет обновлен при следующем входе.
+			_ = store.UpdateUserPassword(ctx, user.Login, pwdHash)
+		}
+	}
+	return startSession(ctx, store, keys, *dbUser, client)
 }
 
-func CheckToken(accessToken string) (string, error) {
+// CheckToken validates access token signature and expiration,
+// session of token must be checked by caller.
+func CheckToken(keys *Keyring, accessToken string) (*types.Claims, error) {
 	token, err := jwt.ParseWithClaims(
 		accessToken,
 		&types.Claims{},
-		func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(signingKey), nil
-		},
+		keys.keyFunc,
 	)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if claims, ok := token.Claims.(*types.Claims); ok && token.Valid {
-		return claims.Login, nil
+		return claims, nil
 	}
 
-	return "", ErrTokenWrong
+	return nil, ErrTokenWrong
 }
@@ -3,3 +3,8 @@ package auth
 import "errors"
 
 var ErrTokenWrong = errors.New("token wrong")
+var ErrUnknownHasher = errors.New("unknown password hasher")
+var ErrHashFormat = errors.New("unsupported password hash format")
+var ErrKeyFormat = errors.New("invalid signing key")
+var ErrSignatureWrong = errors.New("webhook signature wrong")
+var ErrSignatureExpired = errors.New("webhook timestamp is out of tolerance")
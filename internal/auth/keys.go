@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/hrapovd1/loyalty-account/internal/types"
+)
+
+// SigningKey is a key used to sign or verify tokens. Previous keys may have
+// only public part, they are used to verify tokens issued before rotation.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+// Keyring holds current signing key and previous keys which are still
+// accepted by CheckToken.
+type Keyring struct {
+	current SigningKey
+	keys    map[string]SigningKey
+	order   []string
+}
+
+// keyFileEntry is a key description in keys file:
+//
+//	{"keys": [
+//		{"kid": "2023-02", "alg": "ES256", "private_key_file": "es256.pem"},
+//		{"kid": "2023-01", "alg": "HS256", "secret": "..."}
+//	]}
+//
+// The first key is current, others are previous. Relative paths are resolved
+// against keys file directory.
+type keyFileEntry struct {
+	ID             string `json:"kid"`
+	Alg            string `json:"alg"`
+	Secret         string `json:"secret,omitempty"`
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+	PublicKeyFile  string `json:"public_key_file,omitempty"`
+}
+
+// NewKeyring return keyring, the first key is used to sign new tokens.
+func NewKeyring(current SigningKey, previous ...SigningKey) (*Keyring, error) {
+	if current.Private == nil {
+		return nil, fmt.Errorf("%w: current key %q has no private part", ErrKeyFormat, current.ID)
+	}
+	kr := &Keyring{
+		current: current,
+		keys:    make(map[string]SigningKey),
+	}
+	for _, key := range append([]SigningKey{current}, previous...) {
+		if key.ID == "" {
+			return nil, fmt.Errorf("%w: empty kid", ErrKeyFormat)
+		}
+		if _, ok := kr.keys[key.ID]; ok {
+			return nil, fmt.Errorf("%w: duplicate kid %q", ErrKeyFormat, key.ID)
+		}
+		kr.keys[key.ID] = key
+		kr.order = append(kr.order, key.ID)
+	}
+	return kr, nil
+}
+
+// LoadKeyring return keyring from keys file, or with single HS256 key
+// from secret when file is not set. When both are empty keyring gets
+// random key, so tokens are invalidated after restart.
+func LoadKeyring(keysFile, secret string) (*Keyring, error) {
+	if keysFile != "" {
+		return loadKeysFile(keysFile)
+	}
+	if secret != "" {
+		return NewKeyring(hmacKey(secret))
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return NewKeyring(hmacKey(string(buf)))
+}
+
+func hmacKey(secret string) SigningKey {
+	sum := sha256.Sum256([]byte(secret))
+	return SigningKey{
+		ID:      fmt.Sprintf("hs256-%x", sum[:4]),
+		Method:  jwt.SigningMethodHS256,
+		Private: []byte(secret),
+		Public:  []byte(secret),
+	}
+}
+
+func loadKeysFile(name string) (*Keyring, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Keys []keyFileEntry `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyFormat, err)
+	}
+	if len(file.Keys) == 0 {
+		return nil, fmt.Errorf("%w: no keys in %s", ErrKeyFormat, name)
+	}
+	keys := make([]SigningKey, 0, len(file.Keys))
+	for _, entry := range file.Keys {
+		key, err := parseKeyEntry(filepath.Dir(name), entry)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return NewKeyring(keys[0], keys[1:]...)
+}
+
+func parseKeyEntry(dir string, entry keyFileEntry) (SigningKey, error) {
+	key := SigningKey{ID: entry.ID, Method: jwt.GetSigningMethod(entry.Alg)}
+	readPEM := func(name string) ([]byte, error) {
+		if name == "" {
+			return nil, nil
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		return os.ReadFile(name)
+	}
+	private, err := readPEM(entry.PrivateKeyFile)
+	if err != nil {
+		return key, err
+	}
+	public, err := readPEM(entry.PublicKeyFile)
+	if err != nil {
+		return key, err
+	}
+
+	switch entry.Alg {
+	case "HS256":
+		if entry.Secret == "" {
+			return key, fmt.Errorf("%w: key %q has no secret", ErrKeyFormat, entry.ID)
+		}
+		key.Private, key.Public = []byte(entry.Secret), []byte(entry.Secret)
+	case "RS256":
+		if private != nil {
+			var pk *rsa.PrivateKey
+			if pk, err = jwt.ParseRSAPrivateKeyFromPEM(private); err == nil {
+				key.Private, key.Public = pk, &pk.PublicKey
+			}
+		} else if public != nil {
+			key.Public, err = jwt.ParseRSAPublicKeyFromPEM(public)
+		}
+	case "ES256":
+		if private != nil {
+			var pk *ecdsa.PrivateKey
+			if pk, err = jwt.ParseECPrivateKeyFromPEM(private); err == nil {
+				key.Private, key.Public = pk, &pk.PublicKey
+			}
+		} else if public != nil {
+			key.Public, err = jwt.ParseECPublicKeyFromPEM(public)
+		}
+		if pub, ok := key.Public.(*ecdsa.PublicKey); ok && pub.Curve != elliptic.P256() {
+			return key, fmt.Errorf("%w: key %q is not P-256", ErrKeyFormat, entry.ID)
+		}
+	case "EdDSA":
+		if private != nil {
+			var pk interface{}
+			if pk, err = jwt.ParseEdPrivateKeyFromPEM(private); err == nil {
+				edKey := pk.(ed25519.PrivateKey)
+				key.Private, key.Public = edKey, edKey.Public()
+			}
+		} else if public != nil {
+			key.Public, err = jwt.ParseEdPublicKeyFromPEM(public)
+		}
+	default:
+		return key, fmt.Errorf("%w: key %q has unsupported alg %q", ErrKeyFormat, entry.ID, entry.Alg)
+	}
+	if err != nil {
+		return key, fmt.Errorf("%w: key %q: %v", ErrKeyFormat, entry.ID, err)
+	}
+	if key.Public == nil {
+		return key, fmt.Errorf("%w: key %q has no key file", ErrKeyFormat, entry.ID)
+	}
+	return key, nil
+}
+
+// Sign return token signed by current key with its kid in header.
+func (kr *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(kr.current.Method, claims)
+	token.Header["kid"] = kr.current.ID
+	return token.SignedString(kr.current.Private)
+}
+
+// keyFunc chooses verification key by token kid, tokens without kid
+// are checked by current key.
+func (kr *Keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	key := kr.current
+	if kid, ok := token.Header["kid"].(string); ok {
+		if key, ok = kr.keys[kid]; !ok {
+			return nil, fmt.Errorf("unknown signing key: %v", kid)
+		}
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+// JWKS return public keys of keyring in JWK Set format.
+// Symmetric keys are never published.
+func (kr *Keyring) JWKS() types.JWKSet {
+	set := types.JWKSet{Keys: make([]types.JWK, 0, len(kr.order))}
+	b64 := base64.RawURLEncoding.EncodeToString
+	for _, kid := range kr.order {
+		key := kr.keys[kid]
+		jwk := types.JWK{KeyID: kid, Use: "sig", Alg: key.Method.Alg()}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = b64(pub.N.Bytes())
+			jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = pub.Curve.Params().Name
+			jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+			jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = b64(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
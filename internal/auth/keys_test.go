@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/hrapovd1/loyalty-account/internal/types"
+)
+
+func testClaims() *types.Claims {
+	return &types.Claims{
+		Login:     "alice",
+		SessionID: 1,
+		Role:      RoleUser,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+func testECKey(t *testing.T, kid string) SigningKey {
+	t.Helper()
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return SigningKey{ID: kid, Method: jwt.SigningMethodES256, Private: pk, Public: &pk.PublicKey}
+}
+
+func testEdKey(t *testing.T, kid string) SigningKey {
+	t.Helper()
+	pub, pk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Private: pk, Public: pub}
+}
+
+// publicOnly returns previous key as it is kept after rotation.
+func publicOnly(key SigningKey) SigningKey {
+	key.Private = nil
+	return key
+}
+
+func tokenKid(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &types.Claims{})
+	if err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestNewKeyring(t *testing.T) {
+	current := testECKey(t, "current")
+	tests := []struct {
+		name     string
+		current  SigningKey
+		previous []SigningKey
+	}{
+		{"current without private key", publicOnly(current), nil},
+		{"empty kid", SigningKey{Method: jwt.SigningMethodHS256, Private: []byte("s"), Public: []byte("s")}, nil},
+		{"duplicate kid", current, []SigningKey{publicOnly(testEdKey(t, "current"))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeyring(tt.current, tt.previous...); !errors.Is(err, ErrKeyFormat) {
+				t.Errorf("NewKeyring: got %v, want %v", err, ErrKeyFormat)
+			}
+		})
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey, newKey := testECKey(t, "2023-01"), testEdKey(t, "2023-02")
+	before, err := NewKeyring(oldKey)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	oldToken, err := before.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if kid := tokenKid(t, oldToken); kid != oldKey.ID {
+		t.Errorf("token kid = %q, want %q", kid, oldKey.ID)
+	}
+
+	// После ротации новые токены подписывает новый ключ, старые токены
+	// проверяются открытой частью прежнего ключа.
+	after, err := NewKeyring(newKey, publicOnly(oldKey))
+	if err != nil {
+		t.Fatalf("NewKeyring rotated: %v", err)
+	}
+	newToken, err := after.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign rotated: %v", err)
+	}
+	if kid := tokenKid(t, newToken); kid != newKey.ID {
+		t.Errorf("rotated token kid = %q, want %q", kid, newKey.ID)
+	}
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if claims, err := CheckToken(after, token); err != nil || claims.Login != "alice" {
+			t.Errorf("CheckToken %s token = %+v, %v", name, claims, err)
+		}
+	}
+
+	// ключ нового токена неизвестен старому набору
+	if _, err := CheckToken(before, newToken); err == nil {
+		t.Errorf("CheckToken with unknown kid: got nil error")
+	}
+	// прежний ключ удален из набора, его токены больше не принимаются
+	retired, err := NewKeyring(newKey)
+	if err != nil {
+		t.Fatalf("NewKeyring retired: %v", err)
+	}
+	if _, err := CheckToken(retired, oldToken); err == nil {
+		t.Errorf("CheckToken of retired key: got nil error")
+	}
+}
+
+func TestKeyringRejectsAlgMismatch(t *testing.T) {
+	ecKey := testECKey(t, "ec")
+	hsKey := hmacKey("secret")
+	keys, err := NewKeyring(ecKey, hsKey)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	forge := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, testClaims())
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("SignedString: %v", err)
+		}
+		return signed
+	}
+	ecDER, err := x509.MarshalPKIXPublicKey(ecKey.Public)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	tests := []struct {
+		name  string
+		token string
+	}{
+		// открытый ключ ES256 использован как секрет HS256
+		{"hs256 with es256 kid", forge(jwt.SigningMethodHS256, ecKey.ID, ecDER)},
+		{"es256 with hs256 kid", forge(jwt.SigningMethodES256, hsKey.ID, ecKey.Private)},
+		{"hs256 without kid", forge(jwt.SigningMethodHS256, "", []byte("secret"))},
+		{"unknown kid", forge(jwt.SigningMethodES256, "other", ecKey.Private)},
+		{"none", forge(jwt.SigningMethodNone, ecKey.ID, jwt.UnsafeAllowNoneSignatureType)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CheckToken(keys, tt.token); err == nil {
+				t.Errorf("CheckToken: got nil error")
+			}
+		})
+	}
+
+	// токен без kid, выданный до ротации ключей, проверяется текущим ключом
+	if _, err := CheckToken(keys, forge(jwt.SigningMethodES256, "", ecKey.Private)); err != nil {
+		t.Errorf("CheckToken without kid: %v", err)
+	}
+	if _, err := CheckToken(keys, forge(jwt.SigningMethodHS256, hsKey.ID, []byte("secret"))); err != nil {
+		t.Errorf("CheckToken of previous key: %v", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	ecKey, edKey := testECKey(t, "ec"), testEdKey(t, "ed")
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	rsaKey := SigningKey{ID: "rsa", Method: jwt.SigningMethodRS256, Public: &rsaPrivate.PublicKey}
+	keys, err := NewKeyring(ecKey, hmacKey("secret"), publicOnly(edKey), rsaKey)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+
+	set := keys.JWKS()
+	// симметричный ключ не публикуется, порядок ключей сохраняется
+	if len(set.Keys) != 3 {
+		t.Fatalf("JWKS has %d keys, want 3: %+v", len(set.Keys), set.Keys)
+	}
+	b64 := base64.RawURLEncoding
+	decode := func(value string) []byte {
+		data, err := b64.DecodeString(value)
+		if err != nil {
+			t.Fatalf("decode %q: %v", value, err)
+		}
+		return data
+	}
+
+	ec := set.Keys[0]
+	ecPublic := ecKey.Public.(*ecdsa.PublicKey)
+	if ec.KeyType != "EC" || ec.KeyID != "ec" || ec.Use != "sig" || ec.Alg != "ES256" || ec.Curve != "P-256" {
+		t.Errorf("EC key = %+v", ec)
+	}
+	// координаты дополняются нулями до размера кривой
+	if x, y := decode(ec.X), decode(ec.Y); len(x) != 32 || len(y) != 32 ||
+		new(big.Int).SetBytes(x).Cmp(ecPublic.X) != 0 || new(big.Int).SetBytes(y).Cmp(ecPublic.Y) != 0 {
+		t.Errorf("EC key coordinates = %s, %s", ec.X, ec.Y)
+	}
+
+	ed := set.Keys[1]
+	if ed.KeyType != "OKP" || ed.KeyID != "ed" || ed.Alg != "EdDSA" || ed.Curve != "Ed25519" ||
+		string(decode(ed.X)) != string(edKey.Public.(ed25519.PublicKey)) {
+		t.Errorf("OKP key = %+v", ed)
+	}
+
+	rsaJWK := set.Keys[2]
+	if rsaJWK.KeyType != "RSA" || rsaJWK.KeyID != "rsa" || rsaJWK.Alg != "RS256" || rsaJWK.E != "AQAB" ||
+		new(big.Int).SetBytes(decode(rsaJWK.N)).Cmp(rsaPrivate.N) != 0 {
+		t.Errorf("RSA key = %+v", rsaJWK)
+	}
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var raw struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	wantFields := [][]string{
+		{"kty", "kid", "use", "alg", "crv", "x", "y"},
+		{"kty", "kid", "use", "alg", "crv", "x"},
+		{"kty", "kid", "use", "alg", "n", "e"},
+	}
+	for i, key := range raw.Keys {
+		if len(key) != len(wantFields[i]) {
+			t.Errorf("JWK %d fields = %v, want %v", i, key, wantFields[i])
+		}
+		for _, field := range wantFields[i] {
+			if key[field] == "" {
+				t.Errorf("JWK %d has no %q: %v", i, field, key)
+			}
+		}
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("JWKS contains secret: %s", data)
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(ecPrivate)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+	writeFile := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		return path
+	}
+	writeFile("es256.pem", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	keysFile := writeFile("keys.json", []byte(`{"keys": [
+		{"kid": "2023-02", "alg": "ES256", "private_key_file": "es256.pem"},
+		{"kid": "2023-01", "alg": "HS256", "secret": "old secret"}
+	]}`))
+
+	keys, err := LoadKeyring(keysFile, "ignored")
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	token, err := keys.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if kid := tokenKid(t, token); kid != "2023-02" {
+		t.Errorf("token kid = %q, want 2023-02", kid)
+	}
+	previous, _ := NewKeyring(SigningKey{
+		ID: "2023-01", Method: jwt.SigningMethodHS256, Private: []byte("old secret"), Public: []byte("old secret"),
+	})
+	oldToken, _ := previous.Sign(testClaims())
+	if _, err := CheckToken(keys, oldToken); err != nil {
+		t.Errorf("CheckToken of previous key: %v", err)
+	}
+
+	for name, content := range map[string]string{
+		"empty":       `{"keys": []}`,
+		"unknown alg": `{"keys": [{"kid": "a", "alg": "PS256", "secret": "s"}]}`,
+		"no secret":   `{"keys": [{"kid": "a", "alg": "HS256"}]}`,
+		"no key file": `{"keys": [{"kid": "a", "alg": "ES256"}]}`,
+		"broken":      `{"keys": `,
+	} {
+		if _, err := LoadKeyring(writeFile("bad.json", []byte(content)), ""); !errors.Is(err, ErrKeyFormat) {
+			t.Errorf("LoadKeyring %s: got %v, want %v", name, err, ErrKeyFormat)
+		}
+	}
+
+	// один секрет дает один и тот же kid, токены переживают перезапуск
+	first, _ := LoadKeyring("", "secret")
+	second, _ := LoadKeyring("", "secret")
+	token, _ = first.Sign(testClaims())
+	if _, err := CheckToken(second, token); err != nil {
+		t.Errorf("CheckToken after restart: %v", err)
+	}
+	random, _ := LoadKeyring("", "")
+	if _, err := CheckToken(random, token); err == nil {
+		t.Errorf("CheckToken by random key: got nil error")
+	}
+}
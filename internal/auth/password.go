@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HasherArgon2id = "argon2id"
+	HasherBcrypt   = "bcrypt"
+
+	// legacySalt соль, которой хешировались пароли до перехода на PasswordHasher.
+	legacySalt = "sd!oJFDw4-3409sdf."
+)
+
+// PasswordHasher hashes passwords into self-describing strings
+// and verifies passwords against them.
+type PasswordHasher interface {
+	// Hash returns encoded hash of password with random salt.
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded hash.
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded hash was made by another
+	// algorithm or with other parameters and should be replaced.
+	NeedsRehash(encoded string) bool
+}
+
+// NewPasswordHasher return hasher by algorithm name.
+func NewPasswordHasher(name string) (PasswordHasher, error) {
+	switch name {
+	case HasherArgon2id, "":
+		return NewArgon2idHasher(), nil
+	case HasherBcrypt:
+		return NewBcryptHasher(), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownHasher, name)
+}
+
+// Argon2idHasher stores passwords in PHC string format:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+// NewArgon2idHasher return hasher with the second recommended option of
+// RFC 9106: t=3, 64 MiB of memory, 4 lanes, 128-bit salt and 256-bit tag.
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 4,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Time != h.Time ||
+		params.Memory != h.Memory ||
+		params.Threads != h.Threads ||
+		uint32(len(key)) != h.KeyLen ||
+		uint32(len(salt)) != h.SaltLen
+}
+
+func decodeArgon2id(encoded string) (params Argon2idHasher, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != HasherArgon2id {
+		return params, nil, nil, ErrHashFormat
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, ErrHashFormat
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("%w: argon2 version %d", ErrHashFormat, version)
+	}
+	if _, err = fmt.Sscanf(
+		parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads,
+	); err != nil {
+		return params, nil, nil, ErrHashFormat
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, ErrHashFormat
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, nil, nil, ErrHashFormat
+	}
+	return params, salt, key, nil
+}
+
+// BcryptHasher stores passwords in modular crypt format $2a$<cost>$<salt+hash>,
+// salt is generated by bcrypt itself.
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher() *BcryptHasher {
+	return &BcryptHasher{Cost: bcrypt.DefaultCost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// VerifyPassword checks password against hash made by any supported algorithm,
+// including legacy sha1 hashes. Needs rehash is true when stored hash is not
+// in format of current hasher.
+func VerifyPassword(hasher PasswordHasher, password, encoded string) (ok bool, needsRehash bool, err error) {
+	var verifier PasswordHasher
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		verifier = NewArgon2idHasher()
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		verifier = NewBcryptHasher()
+	case !strings.HasPrefix(encoded, "$"):
+		ok = verifyLegacy(password, encoded)
+		return ok, ok, nil
+	default:
+		return false, false, ErrHashFormat
+	}
+	if ok, err = verifier.Verify(password, encoded); err != nil || !ok {
+		return false, false, err
+	}
+	return true, hasher.NeedsRehash(encoded), nil
+}
+
+// verifyLegacy checks hex encoded sha1(password + legacySalt).
+func verifyLegacy(password, encoded string) bool {
+	pwdHash := sha1.New()
+	pwdHash.Write([]byte(password))
+	pwdHash.Write([]byte(legacySalt))
+	return subtle.ConstantTimeCompare(
+		[]byte(fmt.Sprintf("%x", pwdHash.Sum(nil))),
+		[]byte(encoded),
+	) == 1
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/hrapovd1/loyalty-account/internal/memstorage"
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+)
+
+// Параметры хешей в тестах минимальные, чтобы тесты шли быстро.
+func testArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{Time: 1, Memory: 64, Threads: 1, KeyLen: 32, SaltLen: 16}
+}
+
+func testBcryptHasher() *BcryptHasher {
+	return &BcryptHasher{Cost: bcrypt.MinCost}
+}
+
+func legacyHash(password string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(password+legacySalt)))
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	for _, name := range []string{"", HasherArgon2id} {
+		if hasher, err := NewPasswordHasher(name); err != nil || *hasher.(*Argon2idHasher) != *NewArgon2idHasher() {
+			t.Errorf("NewPasswordHasher(%q) = %+v, %v, want default argon2id", name, hasher, err)
+		}
+	}
+	if hasher, err := NewPasswordHasher(HasherBcrypt); err != nil || hasher.(*BcryptHasher).Cost != bcrypt.DefaultCost {
+		t.Errorf("NewPasswordHasher(bcrypt) = %+v, %v", hasher, err)
+	}
+	if _, err := NewPasswordHasher("md5"); !errors.Is(err, ErrUnknownHasher) {
+		t.Errorf("NewPasswordHasher(md5): got %v, want %v", err, ErrUnknownHasher)
+	}
+}
+
+func TestHasherRoundTrip(t *testing.T) {
+	hashers := map[string]PasswordHasher{
+		HasherArgon2id: testArgon2idHasher(),
+		HasherBcrypt:   testBcryptHasher(),
+	}
+	for name, hasher := range hashers {
+		t.Run(name, func(t *testing.T) {
+			hash, err := hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if ok, err := hasher.Verify("correct horse", hash); err != nil || !ok {
+				t.Errorf("Verify right password = %v, %v", ok, err)
+			}
+			if ok, err := hasher.Verify("battery staple", hash); err != nil || ok {
+				t.Errorf("Verify wrong password = %v, %v, want false, nil", ok, err)
+			}
+			if hasher.NeedsRehash(hash) {
+				t.Errorf("NeedsRehash of own hash = true")
+			}
+			// соль случайная, одинаковые пароли дают разные хеши
+			if other, _ := hasher.Hash("correct horse"); other == hash {
+				t.Errorf("Hash is the same for two calls: %q", hash)
+			}
+		})
+	}
+}
+
+func TestArgon2idFormat(t *testing.T) {
+	hash, err := NewArgon2idHasher().Hash("pwd")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=4$") {
+		t.Errorf("Hash = %q, want RFC 9106 parameters", hash)
+	}
+	for _, encoded := range []string{
+		"$argon2id$v=19$m=64,t=1,p=1$salt",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5",
+	} {
+		if _, err := NewArgon2idHasher().Verify("pwd", encoded); !errors.Is(err, ErrHashFormat) {
+			t.Errorf("Verify(%q): got %v, want %v", encoded, err, ErrHashFormat)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	weak, _ := testArgon2idHasher().Hash("pwd")
+	if !NewArgon2idHasher().NeedsRehash(weak) {
+		t.Errorf("argon2id hash with other parameters doesn't need rehash")
+	}
+	bcryptHash, _ := testBcryptHasher().Hash("pwd")
+	if !NewBcryptHasher().NeedsRehash(bcryptHash) {
+		t.Errorf("bcrypt hash with other cost doesn't need rehash")
+	}
+	if !testArgon2idHasher().NeedsRehash(bcryptHash) {
+		t.Errorf("bcrypt hash doesn't need rehash by argon2id hasher")
+	}
+	if !testBcryptHasher().NeedsRehash(weak) {
+		t.Errorf("argon2id hash doesn't need rehash by bcrypt hasher")
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	current := testArgon2idHasher()
+	argonHash, _ := current.Hash("pwd")
+	bcryptHash, _ := testBcryptHasher().Hash("pwd")
+	tests := []struct {
+		name        string
+		password    string
+		encoded     string
+		ok          bool
+		needsRehash bool
+		err         error
+	}{
+		{"current", "pwd", argonHash, true, false, nil},
+		{"current wrong", "other", argonHash, false, false, nil},
+		{"bcrypt", "pwd", bcryptHash, true, true, nil},
+		{"bcrypt wrong", "other", bcryptHash, false, false, nil},
+		{"legacy", "pwd", legacyHash("pwd"), true, true, nil},
+		{"legacy wrong", "other", legacyHash("pwd"), false, false, nil},
+		{"legacy without salt", "pwd", fmt.Sprintf("%x", sha1.Sum([]byte("pwd"))), false, false, nil},
+		{"unknown", "pwd", "$1$salt$hash", false, false, ErrHashFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := VerifyPassword(current, tt.password, tt.encoded)
+			if ok != tt.ok || needsRehash != tt.needsRehash || !errors.Is(err, tt.err) {
+				t.Errorf("VerifyPassword = %v, %v, %v, want %v, %v, %v", ok, needsRehash, err, tt.ok, tt.needsRehash, tt.err)
+			}
+		})
+	}
+}
+
+func TestLegacyRehash(t *testing.T) {
+	ctx := context.Background()
+	store := memstorage.NewMemStorage()
+	hasher := testArgon2idHasher()
+	keys, err := NewKeyring(hmacKey("secret"))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	// пользователь зарегистрирован до перехода на PasswordHasher
+	if err := store.CreateUser(ctx, models.User{Login: "alice", Password: legacyHash("pwd")}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	if _, err := GetToken(ctx, store, hasher, keys, models.User{Login: "alice", Password: "other"}, types.ClientInfo{}); !errors.Is(err, storage.ErrInvalidLoginPassword) {
+		t.Errorf("GetToken wrong password: got %v, want %v", err, storage.ErrInvalidLoginPassword)
+	}
+	if user, _ := store.GetUser(ctx, "alice"); user.Password != legacyHash("pwd") {
+		t.Errorf("hash is replaced after wrong password: %q", user.Password)
+	}
+
+	if _, err := GetToken(ctx, store, hasher, keys, models.User{Login: "alice", Password: "pwd"}, types.ClientInfo{}); err != nil {
+		t.Fatalf("GetToken legacy: %v", err)
+	}
+	user, err := store.GetUser(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if !strings.HasPrefix(user.Password, "$argon2id$") || hasher.NeedsRehash(user.Password) {
+		t.Fatalf("hash after login = %q, want argon2id hash of current parameters", user.Password)
+	}
+	// после замены хеша вход работает по новому хешу, старый пароль тот же
+	if _, err := GetToken(ctx, store, hasher, keys, models.User{Login: "alice", Password: "pwd"}, types.ClientInfo{}); err != nil {
+		t.Errorf("GetToken after rehash: %v", err)
+	}
+	if again, _ := store.GetUser(ctx, "alice"); again.Password != user.Password {
+		t.Errorf("current hash is replaced on login")
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+)
+
+// RefreshToken issues new token pair for refresh token and rotates it,
+// so every refresh token can be used only once.
+func RefreshToken(ctx context.Context, store storage.Storage, keys *Keyring, refreshToken string, client types.ClientInfo) (*types.LoginResponse, error) {
+	if refreshToken == "" {
+		return nil, storage.ErrInvalidRefreshToken
+	}
+	newToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	user, session, err := store.RefreshSession(
+		ctx,
+		hashRefreshToken(refreshToken),
+		models.Session{
+			RefreshHash: hashRefreshToken(newToken),
+			UserAgent:   client.UserAgent,
+			IPAddress:   client.IPAddress,
+			ExpiresAt:   time.Now().Add(refreshDuration).Unix(),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return issueTokens(keys, *user, session.ID, newToken)
+}
+
+// startSession creates session for user and returns its token pair.
+func startSession(ctx context.Context, store storage.Storage, keys *Keyring, user models.User, client types.ClientInfo) (*types.LoginResponse, error) {
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	session, err := store.CreateSession(ctx, user.Login, models.Session{
+		RefreshHash: hashRefreshToken(refreshToken),
+		UserAgent:   client.UserAgent,
+		IPAddress:   client.IPAddress,
+		ExpiresAt:   time.Now().Add(refreshDuration).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return issueTokens(keys, user, session.ID, refreshToken)
+}
+
+func issueTokens(keys *Keyring, user models.User, sessionID uint, refreshToken string) (*types.LoginResponse, error) {
+	token, err := keys.Sign(
+		&types.Claims{
+			Login:     user.Login,
+			SessionID: sessionID,
+			Role:      user.Role,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(expireDuration)),
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+			},
+		})
+	if err != nil {
+		return nil, err
+	}
+	return &types.LoginResponse{Authtoken: token, RefreshToken: refreshToken}, nil
+}
+
+func newRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashRefreshToken returns value stored in db instead of refresh token.
+func hashRefreshToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
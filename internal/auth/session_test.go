@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/hrapovd1/loyalty-account/internal/memstorage"
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+)
+
+func TestRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	store := memstorage.NewMemStorage()
+	hasher := &BcryptHasher{Cost: bcrypt.MinCost}
+	keys, err := NewKeyring(hmacKey("secret"))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	if err := CreateUser(ctx, store, hasher, models.User{Login: "alice", Password: "pwd"}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	client := types.ClientInfo{UserAgent: "curl", IPAddress: "127.0.0.1"}
+
+	login, err := GetToken(ctx, store, hasher, keys, models.User{Login: "alice", Password: "pwd"}, client)
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+	claims, err := CheckToken(keys, login.Authtoken)
+	if err != nil {
+		t.Fatalf("CheckToken: %v", err)
+	}
+	session, err := store.GetSession(ctx, claims.SessionID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	// в хранилище попадает только хеш refresh-токена
+	if session.RefreshHash == login.RefreshToken || session.RefreshHash != hashRefreshToken(login.RefreshToken) {
+		t.Errorf("session refresh hash = %q", session.RefreshHash)
+	}
+
+	// Ротация: каждый обмен выдает новый refresh-токен той же сессии.
+	first, err := RefreshToken(ctx, store, keys, login.RefreshToken, client)
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+	second, err := RefreshToken(ctx, store, keys, first.RefreshToken, client)
+	if err != nil {
+		t.Fatalf("RefreshToken rotated: %v", err)
+	}
+	if first.RefreshToken == login.RefreshToken || second.RefreshToken == first.RefreshToken {
+		t.Errorf("refresh token is not rotated")
+	}
+	refreshed, err := CheckToken(keys, second.Authtoken)
+	if err != nil {
+		t.Fatalf("CheckToken refreshed: %v", err)
+	}
+	if refreshed.SessionID != claims.SessionID || refreshed.Login != "alice" || refreshed.Role != RoleUser {
+		t.Errorf("refreshed claims = %+v, want session %d of alice", refreshed, claims.SessionID)
+	}
+
+	if _, err := RefreshToken(ctx, store, keys, "", client); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Errorf("RefreshToken empty: got %v, want %v", err, storage.ErrInvalidRefreshToken)
+	}
+	if _, err := RefreshToken(ctx, store, keys, "unknown", client); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Errorf("RefreshToken unknown: got %v, want %v", err, storage.ErrInvalidRefreshToken)
+	}
+	if _, err := store.GetSession(ctx, claims.SessionID); err != nil {
+		t.Fatalf("GetSession after unknown token: %v", err)
+	}
+
+	// Повтор уже обмененного токена отзывает всю сессию,
+	// последний выданный в ней токен тоже перестает работать.
+	if _, err := RefreshToken(ctx, store, keys, login.RefreshToken, client); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Errorf("RefreshToken reuse: got %v, want %v", err, storage.ErrInvalidRefreshToken)
+	}
+	if _, err := store.GetSession(ctx, claims.SessionID); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("GetSession after reuse: got %v, want %v", err, storage.ErrSessionNotFound)
+	}
+	if _, err := RefreshToken(ctx, store, keys, second.RefreshToken, client); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Errorf("RefreshToken after reuse: got %v, want %v", err, storage.ErrInvalidRefreshToken)
+	}
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookTolerance is allowed difference between webhook timestamp and
+// current time, older requests are rejected as replays.
+const WebhookTolerance = 5 * time.Minute
+
+const webhookSignaturePrefix = "sha256="
+
+// SignWebhook returns signature of webhook body sent at timestamp, it is
+// "sha256=" and hex of HMAC-SHA256 of "<timestamp>.<body>" by secret.
+func SignWebhook(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return webhookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks signature of webhook body and its timestamp
+// in unix seconds.
+func VerifyWebhook(secret []byte, timestamp string, signature string, body []byte, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignatureWrong
+	}
+	if !strings.HasPrefix(signature, webhookSignaturePrefix) {
+		return ErrSignatureWrong
+	}
+	// Сравнение за постоянное время, чтобы подпись нельзя было подобрать по времени ответа.
+	if !hmac.Equal([]byte(signature), []byte(SignWebhook(secret, ts, body))) {
+		return ErrSignatureWrong
+	}
+	if diff := now.Sub(time.Unix(ts, 0)); diff > WebhookTolerance || diff < -WebhookTolerance {
+		return ErrSignatureExpired
+	}
+	return nil
+}
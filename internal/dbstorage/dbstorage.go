@@ -3,19 +3,30 @@ package dbstorage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
 
 	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/tracing"
 	"github.com/hrapovd1/loyalty-account/internal/types"
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type DBStorage struct {
 	DB *gorm.DB
 }
 
-func NewDB(dsn string) (DBStorage, error) {
+var _ storage.Storage = (*DBStorage)(nil)
+
+// NewDB opens database by dsn, queries are logged by logger.
+func NewDB(dsn string, logger *slog.Logger) (DBStorage, error) {
 	conn, err := sql.Open("pgx", dsn)
 	if err != nil {
 		return DBStorage{}, err
@@ -23,26 +34,32 @@ func NewDB(dsn string) (DBStorage, error) {
 	dbConnect, err := gorm.Open(
 		postgres.New(
 			postgres.Config{Conn: conn}),
-		&gorm.Config{},
+		&gorm.Config{Logger: gormLogger{logger: logger}},
 	)
-	return DBStorage{DB: dbConnect}, err
+	if err != nil {
+		return DBStorage{}, err
+	}
+	if err := dbConnect.Use(tracing.GormPlugin{}); err != nil {
+		return DBStorage{}, err
+	}
+	return DBStorage{DB: dbConnect}, nil
 }
 
 func (ds *DBStorage) Close() error {
 	sqlDB, err := ds.DB.DB()
-	sqlDB.Close()
-	return err
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
 }
 
+// InitDB applies pending migrations, see MigrateUp.
 func (ds *DBStorage) InitDB() error {
-	return ds.DB.AutoMigrate(
-		&models.User{},
-		&models.Account{},
-		&models.Order{},
-		&models.OrderLog{},
-	)
+	_, err := ds.MigrateUp(context.Background())
+	return err
 }
 
+// CreateUser creates user with account of zero balance.
 func (ds *DBStorage) CreateUser(ctx context.Context, user models.User) error {
 	db := ds.DB.WithContext(ctx)
 	var exists bool
@@ -51,20 +68,59 @@ func (ds *DBStorage) CreateUser(ctx context.Context, user models.User) error {
 		Where("login = ?", user.Login).
 		Find(&exists)
 	if exists {
-		return ErrUserAlreadyExists
+		return storage.ErrUserAlreadyExists
 	}
 
-	return db.Create(&user).Error
+	// transaction start
+	err := db.Transaction(
+		func(tx *gorm.DB) error {
+			// gorm пропускает нулевой счет при создании пользователя,
+			// поэтому счет создается явно
+			if err := tx.Omit("Account").Create(&user).Error; err != nil {
+				return err
+			}
+			return tx.Create(&models.Account{UserID: user.ID}).Error
+		},
+	)
+	// transaction end
+	if isUniqueViolation(err) {
+		return storage.ErrUserAlreadyExists
+	}
+	return err
 }
 
 func (ds *DBStorage) GetUser(ctx context.Context, login string) (*models.User, error) {
 	db := ds.DB.WithContext(ctx)
 	var user models.User
 	err := db.First(&user, "login = ?", login).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &user, storage.ErrUserNotFound
+	}
 	return &user, err
 }
 
-func (ds *DBStorage) GetOrders(ctx context.Context, login string) ([]models.Order, error) {
+func (ds *DBStorage) SetUserRole(ctx context.Context, login string, role string) error {
+	db := ds.DB.WithContext(ctx)
+	result := db.Model(&models.User{}).
+		Where("login = ?", login).
+		Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return storage.ErrUserNotFound
+	}
+	return nil
+}
+
+func (ds *DBStorage) UpdateUserPassword(ctx context.Context, login string, password string) error {
+	db := ds.DB.WithContext(ctx)
+	return db.Model(&models.User{}).
+		Where("login = ?", login).
+		Update("password", password).Error
+}
+
+func (ds *DBStorage) GetOrders(ctx context.Context, login string, query types.ListQuery) ([]models.Order, error) {
 	db := ds.DB.WithContext(ctx)
 	orders := make([]models.Order, 0)
 	user, err := ds.GetUser(ctx, login)
@@ -72,9 +128,16 @@ func (ds *DBStorage) GetOrders(ctx context.Context, login string) ([]models.Orde
 		return orders, err
 	}
 
-	db.Where("user_id = ?", user.ID).Find(&orders)
+	tx := db.Where("user_id = ?", user.ID)
+	if len(query.Statuses) > 0 {
+		tx = tx.Where("status IN ?", query.Statuses)
+	}
+	err = listPage(tx, "uploaded_at", query).Find(&orders).Error
+	if err != nil {
+		return orders, err
+	}
 	if len(orders) == 0 {
-		return orders, ErrNoOrders
+		return orders, storage.ErrNoOrders
 	}
 	return orders, err
 }
@@ -91,9 +154,9 @@ func (ds *DBStorage) CreateOrder(ctx context.Context, login string, order models
 	db.Where("number = ?", order.Number).Find(&dbOrder)
 	if dbOrder.Number != "" {
 		if dbOrder.UserID == user.ID {
-			return ErrOrderExists
+			return storage.ErrOrderExists
 		} else {
-			return ErrOrderExistsAnother
+			return storage.ErrOrderExistsAnother
 		}
 	}
 	return db.Create(&order).Error
@@ -106,14 +169,14 @@ func (ds *DBStorage) GetBalance(ctx context.Context, login string) (*types.Balan
 	err := db.Model(&models.User{}).Select(
 		"sum(order_logs.sum) as summ, accounts.balance as balance",
 	).Joins(
-		"left join order_logs on order_logs.user_id = users.id",
+		"left join order_logs on order_logs.user_id = users.id and not order_logs.reversed",
 	).Joins(
 		"left join accounts on accounts.user_id = users.id",
 	).Group("accounts.balance").Where("users.login = ?", login).Scan(&result).Error
 	return &result, err
 }
 
-func (ds *DBStorage) GetOrderLogs(ctx context.Context, login string) ([]models.OrderLog, error) {
+func (ds *DBStorage) GetOrderLogs(ctx context.Context, login string, query types.ListQuery) ([]models.OrderLog, error) {
 	db := ds.DB.WithContext(ctx)
 	orders := make([]models.OrderLog, 0)
 	user, err := ds.GetUser(ctx, login)
@@ -121,9 +184,9 @@ func (ds *DBStorage) GetOrderLogs(ctx context.Context, login string) ([]models.O
 		return orders, err
 	}
 
-	err = db.Where("user_id = ?", user.ID).Find(&orders).Error
+	err = listPage(db.Where("user_id = ?", user.ID), "processed_at", query).Find(&orders).Error
 	if len(orders) == 0 {
-		return orders, ErrNoOrders
+		return orders, storage.ErrNoOrders
 	}
 	return orders, err
 }
@@ -140,26 +203,89 @@ func (ds *DBStorage) WithdrawOrder(ctx context.Context, login string, orderLog m
 			if err := db.First(&user, "login = ?", login).Error; err != nil {
 				return err
 			}
-			// compare balance to sum
-			balance := models.Account{UserID: user.ID}
-			if err := tx.Select("balance").Find(&balance).Error; err != nil {
+			var paid int64
+			if err := tx.Model(&models.OrderLog{}).Where(
+				"user_id = ? AND order_number = ? AND NOT reversed", user.ID, orderLog.OrderNumber,
+			).Count(&paid).Error; err != nil {
 				return err
 			}
-			if balance.Balance.Float64 < orderLog.Sum {
-				return ErrNotEnoughFunds
+			if paid > 0 {
+				return storage.ErrWithdrawalExists
 			}
-			// balance - sum
-			if err := tx.Model(&models.Account{}).Where(
-				"user_id = (?)", user.ID,
-			).UpdateColumn("balance", gorm.Expr("balance - ?", orderLog.Sum)).Error; err != nil {
+			if err := transfer(
+				tx, models.LedgerTransaction{Kind: models.LedgerWithdrawal, Reference: orderLog.OrderNumber},
+				user.ID, models.SystemWithdrawal, -orderLog.Sum,
+			); err != nil {
 				return err
 			}
 			// write orderLog entry
 			orderLog.UserID = user.ID
-			return tx.Create(&orderLog).Error
+			orderLog.ProcessedAt = time.Now().Unix()
+			err := tx.Create(&orderLog).Error
+			// уникальный индекс на номер заказа пользователя
+			if isUniqueViolation(err) {
+				return storage.ErrWithdrawalExists
+			}
+			return err
+		},
+	)
+	// transaction end
+}
+
+// AdjustBalance credits or debits user account on behalf of admin
+// and writes adjustment record.
+func (ds *DBStorage) AdjustBalance(ctx context.Context, login string, adminLogin string, adjustment models.Adjustment) (*models.Adjustment, error) {
+	db := ds.DB.WithContext(ctx)
+	if adjustment.Sum == 0 {
+		return nil, fmt.Errorf("sum must not be 0")
+	}
+	// transaction start
+	err := db.Transaction(
+		func(tx *gorm.DB) error {
+			var user, admin models.User
+			if err := tx.First(&user, "login = ?", login).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return storage.ErrUserNotFound
+				}
+				return err
+			}
+			if err := tx.First(&admin, "login = ?", adminLogin).Error; err != nil {
+				return err
+			}
+			// write adjustment entry
+			adjustment.UserID = user.ID
+			adjustment.AdminID = admin.ID
+			adjustment.AdminLogin = admin.Login
+			if err := tx.Create(&adjustment).Error; err != nil {
+				return err
+			}
+			// debit must not make balance negative
+			return transfer(
+				tx, models.LedgerTransaction{
+					Kind:      models.LedgerAdjustment,
+					Reference: strconv.FormatUint(uint64(adjustment.ID), 10),
+				},
+				user.ID, models.SystemAdjustment, adjustment.Sum,
+			)
 		},
 	)
 	// transaction end
+	return &adjustment, err
+}
+
+func (ds *DBStorage) GetAdjustments(ctx context.Context, login string) ([]models.Adjustment, error) {
+	db := ds.DB.WithContext(ctx)
+	adjustments := make([]models.Adjustment, 0)
+	user, err := ds.GetUser(ctx, login)
+	if err != nil {
+		return adjustments, err
+	}
+
+	err = db.Where("user_id = ?", user.ID).Order("id").Find(&adjustments).Error
+	if len(adjustments) == 0 {
+		return adjustments, storage.ErrNoAdjustments
+	}
+	return adjustments, err
 }
 
 func (ds *DBStorage) DispatchGetOrders(ctx context.Context, status string) ([]string, error) {
@@ -188,33 +314,185 @@ func (ds *DBStorage) DispatchGetOrders(ctx context.Context, status string) ([]st
 	return numList, nil
 }
 
+// DispatchClaimOrders leases up to limit NEW and PROCESSING orders, which
+// are due to check, to instanceID. Orders leased by other instances
+// are skipped until lease expires, own leases are renewed.
+func (ds *DBStorage) DispatchClaimOrders(ctx context.Context, instanceID string, lease time.Duration, limit int) ([]models.Order, error) {
+	db := ds.DB.WithContext(ctx)
+	orders := make([]models.Order, 0)
+	now := time.Now()
+
+	// SKIP LOCKED не дает двум экземплярам выбрать одни и те же
+	// заказы, пока один из них не завершил транзакцию аренды.
+	err := db.Raw(`UPDATE orders SET claimed_by = ?, claimed_until = ?
+	WHERE id IN (
+		SELECT id FROM orders
+		WHERE status IN ? AND next_check_at <= ? AND (claimed_until < ? OR claimed_by = ?)
+		ORDER BY next_check_at, id
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING number, status, attempts`,
+		instanceID, now.Add(lease).Unix(),
+		[]string{models.OrderNew, models.OrderProcessing}, now.Unix(), now.Unix(), instanceID,
+		limit,
+	).Scan(&orders).Error
+	return orders, err
+}
+
+// DispatchRenewClaim extends lease of order claimed by instanceID to
+// lease from now. Order claimed by another instance or in final status
+// returns storage.ErrClaimLost.
+func (ds *DBStorage) DispatchRenewClaim(ctx context.Context, number string, instanceID string, lease time.Duration) error {
+	db := ds.DB.WithContext(ctx)
+	result := db.Model(&models.Order{}).Where(
+		"number = ? AND claimed_by = ? AND status IN ?", number, instanceID, []string{models.OrderNew, models.OrderProcessing},
+	).Update("claimed_until", time.Now().Add(lease).Unix())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return storage.ErrClaimLost
+	}
+	return nil
+}
+
+// DispatchRescheduleOrder sets status, attempts and next check time
+// of order, which is not final yet, empty status is not changed.
+func (ds *DBStorage) DispatchRescheduleOrder(ctx context.Context, order models.Order) error {
+	db := ds.DB.WithContext(ctx)
+	fields := map[string]interface{}{
+		"next_check_at": order.NextCheckAt,
+		"attempts":      order.Attempts,
+	}
+	if order.Status != "" {
+		fields["status"] = order.Status
+	}
+	result := db.Model(&models.Order{}).Where(
+		"number = ? AND status IN ?", order.Number, []string{models.OrderNew, models.OrderProcessing},
+	).Updates(fields)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return storage.ErrOrderFinalized
+	}
+	return nil
+}
+
+// DispatchDeferOrder sets status and next check time of order, which
+// is not final yet, without counting attempt, it is used when accrual
+// system pushed intermediate status. Unknown order returns
+// storage.ErrNoOrders.
+func (ds *DBStorage) DispatchDeferOrder(ctx context.Context, order models.Order) error {
+	db := ds.DB.WithContext(ctx)
+	fields := map[string]interface{}{
+		"next_check_at": order.NextCheckAt,
+	}
+	if order.Status != "" {
+		fields["status"] = order.Status
+	}
+	result := db.Model(&models.Order{}).Where(
+		"number = ? AND status IN ?", order.Number, []string{models.OrderNew, models.OrderProcessing},
+	).Updates(fields)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+	var count int64
+	if err := db.Model(&models.Order{}).Where("number = ?", order.Number).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return storage.ErrNoOrders
+	}
+	return storage.ErrOrderFinalized
+}
+
+// DispatchMarkStuck moves NEW and PROCESSING orders uploaded before
+// uploadedBefore to STUCK status and returns their count.
+func (ds *DBStorage) DispatchMarkStuck(ctx context.Context, uploadedBefore int64) (int64, error) {
+	db := ds.DB.WithContext(ctx)
+	result := db.Model(&models.Order{}).Where(
+		"status IN ? AND uploaded_at < ?", []string{models.OrderNew, models.OrderProcessing}, uploadedBefore,
+	).Update("status", models.OrderStuck)
+	return result.RowsAffected, result.Error
+}
+
+// GetStuckOrders returns STUCK orders with their owners.
+func (ds *DBStorage) GetStuckOrders(ctx context.Context) ([]models.Order, error) {
+	db := ds.DB.WithContext(ctx)
+	orders := make([]models.Order, 0)
+
+	err := db.Preload("User", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "login")
+	}).Where("status = ?", models.OrderStuck).Order("uploaded_at").Find(&orders).Error
+	if len(orders) == 0 {
+		return orders, storage.ErrNoOrders
+	}
+	return orders, err
+}
+
+// DispatchUpdateOrder sets status of order, which is not final yet,
+// and credits accrual of PROCESSED order to its owner. Update of order
+// in final status returns storage.ErrOrderFinalized.
 func (ds *DBStorage) DispatchUpdateOrder(ctx context.Context, order models.Order) error {
 	db := ds.DB.WithContext(ctx)
 	// transaction start
 	return db.Transaction(
 		func(tx *gorm.DB) error {
-			if err := tx.Model(&models.Order{}).Where(
+			// Условие на статус делает переход в финальный статус однократным:
+			// конкурирующая транзакция дождется блокировки строки и не найдет заказ.
+			var dbOrder models.Order
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "user_id", "status").Where(
 				"number = ?", order.Number,
+			).Take(&dbOrder).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return storage.ErrNoOrders
+				}
+				return err
+			}
+			if !isPending(dbOrder.Status) {
+				return storage.ErrOrderFinalized
+			}
+			if err := tx.Model(&models.Order{}).Where(
+				"id = ?", dbOrder.ID,
 			).Updates(
 				models.Order{Status: order.Status, Accrual: order.Accrual},
 			).Error; err != nil {
 				return err
 			}
-			if order.Accrual > 0 {
-				if err := tx.Model(&models.Account{}).Where(
-					"user_id = (?)",
-					tx.Model(&models.Order{}).Select("id").Where(
-						"number = ?", order.Number,
-					),
-				).UpdateColumn(
-					"balance",
-					gorm.Expr("balance + ?", order.Accrual),
-				).Error; err != nil {
-					return err
-				}
+			if order.Status != models.OrderProcessed || order.Accrual <= 0 {
+				return nil
+			}
+			err := transfer(
+				tx, models.LedgerTransaction{Kind: models.LedgerAccrual, Reference: order.Number, OrderID: &dbOrder.ID},
+				dbOrder.UserID, models.SystemAccrual, order.Accrual,
+			)
+			// уникальный индекс на начисление по заказу
+			if isUniqueViolation(err) {
+				return storage.ErrOrderFinalized
 			}
-			return nil
+			return err
 		},
 	)
 	// transaction end
 }
+
+// isPending reports whether order status is not final, polling of
+// STUCK order is stopped, but late accrual answer is still accepted.
+func isPending(status string) bool {
+	switch status {
+	case models.OrderNew, models.OrderProcessing, models.OrderRegistered, models.OrderStuck:
+		return true
+	}
+	return false
+}
+
+// isUniqueViolation reports whether err is postgres unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,41 @@
+package dbstorage_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/hrapovd1/loyalty-account/internal/dbstorage"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/storage/storagetest"
+)
+
+// TEST_DATABASE_URI задает отдельную БД для тестов, ее схема public
+// пересоздается перед каждым тестом набора.
+const testDSNEnv = "TEST_DATABASE_URI"
+
+func TestStorage(t *testing.T) {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSNEnv)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		store, err := dbstorage.NewDB(dsn, logger)
+		if err != nil {
+			t.Fatalf("NewDB: %v", err)
+		}
+		if err := store.DB.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public").Error; err != nil {
+			store.Close()
+			t.Fatalf("reset schema: %v", err)
+		}
+		if _, err := store.MigrateUp(context.Background()); err != nil {
+			store.Close()
+			t.Fatalf("MigrateUp: %v", err)
+		}
+		return &store
+	})
+}
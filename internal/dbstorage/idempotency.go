@@ -0,0 +1,72 @@
+package dbstorage
+
+import (
+	"context"
+
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CreateIdempotencyKey saves key of user request before the request is
+// processed. If user already used the key, the saved key is returned
+// with storage.ErrIdempotencyKeyExists. Keys of user created before
+// expiredBefore are removed first.
+func (ds *DBStorage) CreateIdempotencyKey(ctx context.Context, login string, key models.IdempotencyKey, expiredBefore int64) (*models.IdempotencyKey, error) {
+	db := ds.DB.WithContext(ctx)
+	user, err := ds.GetUser(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+	key.UserID = user.ID
+	key.Status = 0
+	key.ContentType = ""
+	key.Body = nil
+	var saved models.IdempotencyKey
+	// transaction start
+	err = db.Transaction(
+		func(tx *gorm.DB) error {
+			if err := tx.Where(
+				"user_id = ? AND created_at < ?", user.ID, expiredBefore,
+			).Delete(&models.IdempotencyKey{}).Error; err != nil {
+				return err
+			}
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&key)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				saved = key
+				return nil
+			}
+			// Ключ уже сохранен другим запросом.
+			if err := tx.Where(
+				"user_id = ? AND key = ?", user.ID, key.Key,
+			).Take(&saved).Error; err != nil {
+				return err
+			}
+			return storage.ErrIdempotencyKeyExists
+		},
+	)
+	// transaction end
+	return &saved, err
+}
+
+// CompleteIdempotencyKey saves response to request made with key.
+func (ds *DBStorage) CompleteIdempotencyKey(ctx context.Context, key models.IdempotencyKey) error {
+	db := ds.DB.WithContext(ctx)
+	return db.Model(&models.IdempotencyKey{}).Where(
+		"id = ?", key.ID,
+	).Updates(map[string]interface{}{
+		"status":       key.Status,
+		"content_type": key.ContentType,
+		"body":         key.Body,
+	}).Error
+}
+
+// DeleteIdempotencyKey removes key, so request with it can be repeated.
+func (ds *DBStorage) DeleteIdempotencyKey(ctx context.Context, id uint) error {
+	db := ds.DB.WithContext(ctx)
+	return db.Delete(&models.IdempotencyKey{}, id).Error
+}
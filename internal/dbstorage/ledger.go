@@ -0,0 +1,194 @@
+package dbstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/money"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// post writes balanced ledger transaction and applies its entries to
+// cached balances of user accounts, which must not become negative.
+// Balances of system accounts are not cached, so concurrent postings
+// don't contend on their rows.
+func post(tx *gorm.DB, ltx *models.LedgerTransaction) error {
+	if len(ltx.Entries) < 2 {
+		return storage.ErrUnbalanced
+	}
+	var total money.Amount
+	ids := make([]uint, 0, len(ltx.Entries))
+	for _, entry := range ltx.Entries {
+		if entry.Amount == 0 {
+			return fmt.Errorf("entry amount must not be 0")
+		}
+		total += entry.Amount
+		ids = append(ids, entry.AccountID)
+	}
+	if total != 0 {
+		return storage.ErrUnbalanced
+	}
+
+	var accounts []models.Account
+	if err := tx.Select("id", "code").Where("id IN ?", ids).Find(&accounts).Error; err != nil {
+		return err
+	}
+	system := make(map[uint]bool, len(accounts))
+	for _, account := range accounts {
+		system[account.ID] = account.Code != nil
+	}
+	for _, entry := range ltx.Entries {
+		isSystem, ok := system[entry.AccountID]
+		if !ok {
+			return fmt.Errorf("account %d not found", entry.AccountID)
+		}
+		if isSystem {
+			continue
+		}
+		// balance + amount, условие на баланс не дает уйти в минус
+		// при параллельных списаниях
+		result := tx.Model(&models.Account{}).Where(
+			"id = ? AND balance + ? >= 0", entry.AccountID, entry.Amount,
+		).UpdateColumn("balance", gorm.Expr("balance + ?", entry.Amount))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return storage.ErrNotEnoughFunds
+		}
+	}
+	return tx.Create(ltx).Error
+}
+
+// transfer posts transaction ltx, which moves amount from system
+// account with code to account of user, negative amount moves it back.
+func transfer(tx *gorm.DB, ltx models.LedgerTransaction, userID uint, code string, amount money.Amount) error {
+	var userAccount, systemAccount models.Account
+	if err := tx.Select("id").Where("user_id = ?", userID).Take(&userAccount).Error; err != nil {
+		return err
+	}
+	if err := tx.Select("id").Where("code = ?", code).Take(&systemAccount).Error; err != nil {
+		return err
+	}
+	ltx.Entries = []models.LedgerEntry{
+		{AccountID: userAccount.ID, Amount: amount},
+		{AccountID: systemAccount.ID, Amount: -amount},
+	}
+	return post(tx, &ltx)
+}
+
+// GetLedger returns entries of user account with their transactions.
+func (ds *DBStorage) GetLedger(ctx context.Context, login string) ([]models.LedgerEntry, error) {
+	db := ds.DB.WithContext(ctx)
+	entries := make([]models.LedgerEntry, 0)
+	user, err := ds.GetUser(ctx, login)
+	if err != nil {
+		return entries, err
+	}
+
+	err = db.Preload("Transaction").Joins(
+		"JOIN accounts ON accounts.id = ledger_entries.account_id",
+	).Where("accounts.user_id = ?", user.ID).Order("ledger_entries.id").Find(&entries).Error
+	return entries, err
+}
+
+// reverseWithdrawal marks withdrawal of ledger transaction $1 for order
+// $2 as reversed. Historical duplicate is marked first, so the remaining
+// withdrawal still keeps order paid.
+const reverseWithdrawal = `UPDATE order_logs SET reversed = true WHERE id = (
+	SELECT l.id FROM order_logs l
+	JOIN accounts a ON a.user_id = l.user_id
+	JOIN ledger_entries e ON e.account_id = a.id
+	WHERE e.transaction_id = ? AND l.order_number = ? AND NOT l.reversed
+	ORDER BY l.duplicate DESC, l.id
+	LIMIT 1
+)`
+
+// ReverseTransaction posts transaction with negated entries of
+// transaction id, each transaction may be reversed only once.
+func (ds *DBStorage) ReverseTransaction(ctx context.Context, id uint) (*models.LedgerTransaction, error) {
+	db := ds.DB.WithContext(ctx)
+	var reversal models.LedgerTransaction
+	// transaction start
+	err := db.Transaction(
+		func(tx *gorm.DB) error {
+			var original models.LedgerTransaction
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Take(&original, id).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return storage.ErrTransactionNotFound
+				}
+				return err
+			}
+			if original.Kind == models.LedgerReversal {
+				return storage.ErrNotReversible
+			}
+			var reversed int64
+			if err := tx.Model(&models.LedgerTransaction{}).Where(
+				"reversal_of = ?", original.ID,
+			).Count(&reversed).Error; err != nil {
+				return err
+			}
+			if reversed > 0 {
+				return storage.ErrAlreadyReversed
+			}
+			var entries []models.LedgerEntry
+			if err := tx.Where("transaction_id = ?", original.ID).Order("id").Find(&entries).Error; err != nil {
+				return err
+			}
+			reversal = models.LedgerTransaction{
+				Kind:       models.LedgerReversal,
+				Reference:  original.Reference,
+				ReversalOf: &original.ID,
+			}
+			for _, entry := range entries {
+				reversal.Entries = append(reversal.Entries, models.LedgerEntry{
+					AccountID: entry.AccountID,
+					Amount:    -entry.Amount,
+				})
+			}
+			if err := post(tx, &reversal); err != nil {
+				return err
+			}
+			if original.Kind != models.LedgerWithdrawal {
+				return nil
+			}
+			return tx.Exec(reverseWithdrawal, original.ID, original.Reference).Error
+		},
+	)
+	// transaction end
+	return &reversal, err
+}
+
+// CheckLedger recomputes balances of user accounts from ledger and
+// returns accounts with wrong cached balance and unbalanced transactions.
+func (ds *DBStorage) CheckLedger(ctx context.Context) ([]types.LedgerDiscrepancy, error) {
+	db := ds.DB.WithContext(ctx)
+	discrepancies := make([]types.LedgerDiscrepancy, 0)
+
+	if err := db.Raw(`SELECT a.id AS account_id, COALESCE(u.login, '') AS account,
+		COALESCE(s.total, 0) AS expected, a.balance AS actual
+	FROM accounts a
+	LEFT JOIN users u ON u.id = a.user_id
+	LEFT JOIN (
+		SELECT account_id, sum(amount) AS total FROM ledger_entries GROUP BY account_id
+	) s ON s.account_id = a.id
+	WHERE a.code IS NULL AND a.balance <> COALESCE(s.total, 0)
+	ORDER BY a.id`).Scan(&discrepancies).Error; err != nil {
+		return discrepancies, err
+	}
+
+	unbalanced := make([]types.LedgerDiscrepancy, 0)
+	if err := db.Raw(`SELECT transaction_id, 0 AS expected, sum(amount) AS actual
+	FROM ledger_entries
+	GROUP BY transaction_id
+	HAVING sum(amount) <> 0
+	ORDER BY transaction_id`).Scan(&unbalanced).Error; err != nil {
+		return discrepancies, err
+	}
+	return append(discrepancies, unbalanced...), nil
+}
@@ -0,0 +1,30 @@
+package dbstorage
+
+import (
+	"github.com/hrapovd1/loyalty-account/internal/types"
+	"gorm.io/gorm"
+)
+
+// listPage adds to tx conditions of query on time column, order by time
+// and id and limit of page.
+func listPage(tx *gorm.DB, column string, query types.ListQuery) *gorm.DB {
+	if query.From > 0 {
+		tx = tx.Where(column+" >= ?", query.From)
+	}
+	if query.To > 0 {
+		tx = tx.Where(column+" < ?", query.To)
+	}
+	direction, compare := "ASC", ">"
+	if query.Desc {
+		direction, compare = "DESC", "<"
+	}
+	if query.After != nil {
+		// сравнение строк использует индекс (user_id, <column>, id)
+		tx = tx.Where("("+column+", id) "+compare+" (?, ?)", query.After.Time, query.After.ID)
+	}
+	tx = tx.Order(column + " " + direction).Order("id " + direction)
+	if query.Limit > 0 {
+		tx = tx.Limit(query.Limit)
+	}
+	return tx
+}
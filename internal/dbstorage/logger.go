@@ -0,0 +1,69 @@
+package dbstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+
+	"github.com/hrapovd1/loyalty-account/internal/logging"
+)
+
+// slowQueryThreshold is duration of query which is logged as slow.
+const slowQueryThreshold = 200 * time.Millisecond
+
+// gormLogger writes gorm logs to slog logger: failed queries as errors,
+// slow queries as warnings and all queries on debug level. Records are
+// tagged with request ID from context of query. Parameters are not
+// logged, because they may contain personal data and password hashes.
+type gormLogger struct {
+	logger *slog.Logger
+}
+
+var (
+	_ gormlogger.Interface = gormLogger{}
+	_ gorm.ParamsFilter    = gormLogger{}
+)
+
+// LogMode is ignored, level is set by slog handler.
+func (l gormLogger) LogMode(gormlogger.LogLevel) gormlogger.Interface {
+	return l
+}
+
+func (l gormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	l.logger.InfoContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (l gormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	l.logger.WarnContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (l gormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	l.logger.ErrorContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+// ParamsFilter drops parameters of query, so logged SQL keeps
+// placeholders instead of values.
+func (gormLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
+
+func (l gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+	switch {
+	// отсутствие записи и отмена запроса обрабатываются вызывающим кодом
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, context.Canceled):
+		sql, rows := fc()
+		l.logger.ErrorContext(ctx, "query failed", logging.Err(err), "sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds())
+	case elapsed > slowQueryThreshold:
+		sql, rows := fc()
+		l.logger.WarnContext(ctx, "slow query", "sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds())
+	case l.logger.Enabled(ctx, slog.LevelDebug):
+		sql, rows := fc()
+		l.logger.DebugContext(ctx, "query", "sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds())
+	}
+}
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS adjustments;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS order_logs;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS accounts;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,68 @@
+-- Базовая схема. Совпадает со схемой, которую создавал gorm AutoMigrate,
+-- поэтому на существующей базе миграция ничего не меняет.
+CREATE TABLE IF NOT EXISTS users (
+    id       BIGSERIAL PRIMARY KEY,
+    login    TEXT,
+    password TEXT,
+    role     TEXT NOT NULL DEFAULT 'user'
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_logins ON users (login);
+
+CREATE TABLE IF NOT EXISTS accounts (
+    id      BIGSERIAL PRIMARY KEY,
+    user_id BIGINT,
+    balance DECIMAL,
+    CONSTRAINT fk_users_account FOREIGN KEY (user_id) REFERENCES users (id)
+        ON UPDATE CASCADE ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id          BIGSERIAL PRIMARY KEY,
+    user_id     BIGINT,
+    number      TEXT,
+    status      TEXT,
+    accrual     DECIMAL,
+    uploaded_at BIGINT,
+    CONSTRAINT fk_users_orders FOREIGN KEY (user_id) REFERENCES users (id)
+        ON UPDATE CASCADE ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_numbers ON orders (number DESC);
+
+CREATE TABLE IF NOT EXISTS order_logs (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      BIGINT,
+    order_number TEXT,
+    sum          DECIMAL,
+    processed_at BIGINT,
+    CONSTRAINT fk_users_order_logs FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      BIGINT,
+    refresh_hash TEXT,
+    user_agent   TEXT,
+    ip_address   TEXT,
+    created_at   BIGINT,
+    last_used_at BIGINT,
+    expires_at   BIGINT,
+    revoked_at   BIGINT,
+    CONSTRAINT fk_users_sessions FOREIGN KEY (user_id) REFERENCES users (id)
+        ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_hashes ON sessions (refresh_hash);
+
+CREATE TABLE IF NOT EXISTS adjustments (
+    id          BIGSERIAL PRIMARY KEY,
+    user_id     BIGINT,
+    admin_id    BIGINT,
+    admin_login TEXT,
+    sum         DECIMAL,
+    reason_code TEXT,
+    comment     TEXT,
+    created_at  BIGINT,
+    CONSTRAINT fk_users_adjustments FOREIGN KEY (user_id) REFERENCES users (id)
+);
+CREATE INDEX IF NOT EXISTS idx_adjustments_user_id ON adjustments (user_id);
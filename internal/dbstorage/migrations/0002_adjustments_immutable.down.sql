@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS adjustments_immutable ON adjustments;
+DROP FUNCTION IF EXISTS adjustments_immutable();
@@ -0,0 +1,11 @@
+-- Ручные корректировки баланса являются журналом аудита
+-- и не могут быть изменены или удалены.
+CREATE OR REPLACE FUNCTION adjustments_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'adjustments are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER adjustments_immutable
+    BEFORE UPDATE OR DELETE ON adjustments
+    FOR EACH ROW EXECUTE FUNCTION adjustments_immutable();
@@ -0,0 +1,9 @@
+-- Округленные значения не восстанавливаются, журнал округлений удаляется.
+ALTER TABLE accounts
+    ALTER COLUMN balance TYPE DECIMAL,
+    ALTER COLUMN balance DROP DEFAULT,
+    ALTER COLUMN balance DROP NOT NULL;
+ALTER TABLE orders ALTER COLUMN accrual TYPE DECIMAL;
+ALTER TABLE order_logs ALTER COLUMN sum TYPE DECIMAL;
+ALTER TABLE adjustments ALTER COLUMN sum TYPE DECIMAL;
+DROP TABLE IF EXISTS amount_roundings;
@@ -0,0 +1,36 @@
+-- Денежные суммы хранятся с точностью до сотых. Значения с большей
+-- точностью округляются (половина от нуля, как round), а исходное
+-- и новое значение записываются в журнал amount_roundings.
+CREATE TABLE amount_roundings (
+    id          BIGSERIAL PRIMARY KEY,
+    table_name  TEXT NOT NULL,
+    row_id      BIGINT NOT NULL,
+    column_name TEXT NOT NULL,
+    old_value   DECIMAL NOT NULL,
+    new_value   NUMERIC(20, 2) NOT NULL,
+    created_at  BIGINT NOT NULL DEFAULT extract(epoch FROM now())::BIGINT
+);
+
+INSERT INTO amount_roundings (table_name, row_id, column_name, old_value, new_value)
+SELECT 'accounts', id, 'balance', balance, round(balance, 2)
+    FROM accounts WHERE balance <> round(balance, 2)
+UNION ALL
+SELECT 'orders', id, 'accrual', accrual, round(accrual, 2)
+    FROM orders WHERE accrual <> round(accrual, 2)
+UNION ALL
+SELECT 'order_logs', id, 'sum', sum, round(sum, 2)
+    FROM order_logs WHERE sum <> round(sum, 2)
+UNION ALL
+SELECT 'adjustments', id, 'sum', sum, round(sum, 2)
+    FROM adjustments WHERE sum <> round(sum, 2);
+
+-- Смена типа не вызывает триггеры UPDATE, поэтому корректировки
+-- остаются неизменяемыми, а их округление видно в журнале.
+UPDATE accounts SET balance = 0 WHERE balance IS NULL;
+ALTER TABLE accounts
+    ALTER COLUMN balance TYPE NUMERIC(20, 2) USING round(balance, 2),
+    ALTER COLUMN balance SET DEFAULT 0,
+    ALTER COLUMN balance SET NOT NULL;
+ALTER TABLE orders ALTER COLUMN accrual TYPE NUMERIC(20, 2) USING round(accrual, 2);
+ALTER TABLE order_logs ALTER COLUMN sum TYPE NUMERIC(20, 2) USING round(sum, 2);
+ALTER TABLE adjustments ALTER COLUMN sum TYPE NUMERIC(20, 2) USING round(sum, 2);
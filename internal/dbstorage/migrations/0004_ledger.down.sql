@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS ledger_entries;
+DROP TABLE IF EXISTS ledger_transactions;
+DROP FUNCTION IF EXISTS ledger_balanced();
+DROP FUNCTION IF EXISTS ledger_immutable();
+DELETE FROM accounts WHERE code IS NOT NULL;
+DROP INDEX IF EXISTS idx_account_codes;
+ALTER TABLE accounts DROP COLUMN IF EXISTS code;
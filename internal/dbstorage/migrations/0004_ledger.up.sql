@@ -0,0 +1,126 @@
+-- Двойная запись: каждая операция с баллами является транзакцией из
+-- проводок, сумма которых равна нулю. Баланс пользователя в accounts
+-- является кешем суммы его проводок, у системных счетов он не ведется.
+ALTER TABLE accounts ADD COLUMN code TEXT;
+CREATE UNIQUE INDEX idx_account_codes ON accounts (code);
+
+INSERT INTO accounts (code, balance) VALUES
+    ('system:accrual', 0),
+    ('system:withdrawal', 0),
+    ('system:adjustment', 0);
+
+CREATE TABLE ledger_transactions (
+    id          BIGSERIAL PRIMARY KEY,
+    kind        TEXT NOT NULL
+        CHECK (kind IN ('accrual', 'withdrawal', 'adjustment', 'reversal')),
+    reference   TEXT NOT NULL DEFAULT '',
+    reversal_of BIGINT REFERENCES ledger_transactions (id),
+    created_at  BIGINT NOT NULL
+);
+-- Транзакцию можно отменить только один раз.
+CREATE UNIQUE INDEX idx_ledger_reversals ON ledger_transactions (reversal_of);
+
+CREATE TABLE ledger_entries (
+    id             BIGSERIAL PRIMARY KEY,
+    transaction_id BIGINT NOT NULL REFERENCES ledger_transactions (id),
+    account_id     BIGINT NOT NULL REFERENCES accounts (id),
+    amount         NUMERIC(20, 2) NOT NULL CHECK (amount <> 0)
+);
+CREATE INDEX idx_ledger_entries_transaction_id ON ledger_entries (transaction_id);
+CREATE INDEX idx_ledger_entries_account_id ON ledger_entries (account_id);
+
+CREATE OR REPLACE FUNCTION ledger_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_transactions_immutable
+    BEFORE UPDATE OR DELETE ON ledger_transactions
+    FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
+
+CREATE TRIGGER ledger_entries_immutable
+    BEFORE UPDATE OR DELETE ON ledger_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
+
+-- Проверка баланса транзакции откладывается до commit, когда
+-- записаны все ее проводки.
+CREATE OR REPLACE FUNCTION ledger_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT sum(amount) FROM ledger_entries WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger transaction % is unbalanced', NEW.transaction_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER ledger_entries_balanced
+    AFTER INSERT ON ledger_entries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION ledger_balanced();
+
+-- Перенос истории: начисления по обработанным заказам, списания
+-- и ручные корректировки. Расхождение с текущим балансом (например,
+-- после ручных правок в базе) записывается корректировкой, так что
+-- кеш баланса не меняется.
+DO $$
+DECLARE
+    rec RECORD;
+    tx_id BIGINT;
+    acc_accrual BIGINT := (SELECT id FROM accounts WHERE code = 'system:accrual');
+    acc_withdrawal BIGINT := (SELECT id FROM accounts WHERE code = 'system:withdrawal');
+    acc_adjustment BIGINT := (SELECT id FROM accounts WHERE code = 'system:adjustment');
+BEGIN
+    FOR rec IN
+        SELECT o.number, o.accrual, o.uploaded_at, a.id AS account_id
+        FROM orders o JOIN accounts a ON a.user_id = o.user_id
+        WHERE o.status = 'PROCESSED' AND o.accrual > 0
+        ORDER BY o.id
+    LOOP
+        INSERT INTO ledger_transactions (kind, reference, created_at)
+            VALUES ('accrual', rec.number, rec.uploaded_at) RETURNING id INTO tx_id;
+        INSERT INTO ledger_entries (transaction_id, account_id, amount)
+            VALUES (tx_id, rec.account_id, rec.accrual), (tx_id, acc_accrual, -rec.accrual);
+    END LOOP;
+
+    FOR rec IN
+        SELECT l.order_number, l.sum, l.processed_at, a.id AS account_id
+        FROM order_logs l JOIN accounts a ON a.user_id = l.user_id
+        WHERE l.sum > 0
+        ORDER BY l.id
+    LOOP
+        INSERT INTO ledger_transactions (kind, reference, created_at)
+            VALUES ('withdrawal', rec.order_number, rec.processed_at) RETURNING id INTO tx_id;
+        INSERT INTO ledger_entries (transaction_id, account_id, amount)
+            VALUES (tx_id, rec.account_id, -rec.sum), (tx_id, acc_withdrawal, rec.sum);
+    END LOOP;
+
+    FOR rec IN
+        SELECT j.id, j.sum, j.created_at, a.id AS account_id
+        FROM adjustments j JOIN accounts a ON a.user_id = j.user_id
+        WHERE j.sum <> 0
+        ORDER BY j.id
+    LOOP
+        INSERT INTO ledger_transactions (kind, reference, created_at)
+            VALUES ('adjustment', rec.id::TEXT, rec.created_at) RETURNING id INTO tx_id;
+        INSERT INTO ledger_entries (transaction_id, account_id, amount)
+            VALUES (tx_id, rec.account_id, rec.sum), (tx_id, acc_adjustment, -rec.sum);
+    END LOOP;
+
+    FOR rec IN
+        SELECT a.id AS account_id,
+            a.balance - COALESCE((SELECT sum(e.amount) FROM ledger_entries e WHERE e.account_id = a.id), 0) AS diff
+        FROM accounts a
+        WHERE a.code IS NULL
+        ORDER BY a.id
+    LOOP
+        IF rec.diff <> 0 THEN
+            INSERT INTO ledger_transactions (kind, reference, created_at)
+                VALUES ('adjustment', 'opening balance', extract(epoch FROM now())::BIGINT)
+                RETURNING id INTO tx_id;
+            INSERT INTO ledger_entries (transaction_id, account_id, amount)
+                VALUES (tx_id, rec.account_id, rec.diff), (tx_id, acc_adjustment, -rec.diff);
+        END IF;
+    END LOOP;
+END;
+$$;
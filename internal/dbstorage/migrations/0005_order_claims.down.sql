@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_orders_pending;
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS claimed_until,
+    DROP COLUMN IF EXISTS claimed_by;
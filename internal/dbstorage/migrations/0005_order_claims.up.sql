@@ -0,0 +1,8 @@
+-- Аренда заказа экземпляром диспетчера: пока claimed_until не истекло,
+-- заказ опрашивает только экземпляр claimed_by.
+ALTER TABLE orders
+    ADD COLUMN claimed_by TEXT NOT NULL DEFAULT '',
+    ADD COLUMN claimed_until BIGINT NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_orders_pending ON orders (claimed_until)
+    WHERE status IN ('NEW', 'PROCESSING');
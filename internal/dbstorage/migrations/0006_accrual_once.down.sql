@@ -0,0 +1,3 @@
+ALTER TABLE ledger_transactions DROP CONSTRAINT IF EXISTS ledger_accrual_order;
+DROP INDEX IF EXISTS idx_ledger_order_accruals;
+ALTER TABLE ledger_transactions DROP COLUMN IF EXISTS order_id;
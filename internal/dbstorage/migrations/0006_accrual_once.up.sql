@@ -0,0 +1,45 @@
+-- Начисление по заказу проводится в журнале не более одного раза:
+-- транзакция начисления ссылается на заказ, ссылка уникальна.
+ALTER TABLE ledger_transactions ADD COLUMN order_id BIGINT REFERENCES orders (id);
+
+ALTER TABLE ledger_transactions DISABLE TRIGGER ledger_transactions_immutable;
+UPDATE ledger_transactions t SET order_id = o.id
+FROM orders o
+WHERE t.kind = 'accrual' AND o.number = t.reference
+    AND t.id = (
+        SELECT min(d.id) FROM ledger_transactions d
+        WHERE d.kind = 'accrual' AND d.reference = t.reference
+    );
+ALTER TABLE ledger_transactions ENABLE TRIGGER ledger_transactions_immutable;
+
+-- Повторные начисления, если они успели случиться, отменяются
+-- обратными проводками.
+DO $$
+DECLARE
+    rec RECORD;
+    tx_id BIGINT;
+BEGIN
+    FOR rec IN
+        SELECT t.id, t.reference FROM ledger_transactions t
+        WHERE t.kind = 'accrual' AND t.order_id IS NULL
+            AND NOT EXISTS (SELECT 1 FROM ledger_transactions r WHERE r.reversal_of = t.id)
+        ORDER BY t.id
+    LOOP
+        INSERT INTO ledger_transactions (kind, reference, reversal_of, created_at)
+            VALUES ('reversal', rec.reference, rec.id, extract(epoch FROM now())::BIGINT)
+            RETURNING id INTO tx_id;
+        INSERT INTO ledger_entries (transaction_id, account_id, amount)
+            SELECT tx_id, account_id, -amount FROM ledger_entries WHERE transaction_id = rec.id;
+        UPDATE accounts a SET balance = a.balance - e.amount
+            FROM ledger_entries e
+            WHERE e.transaction_id = rec.id AND a.id = e.account_id AND a.code IS NULL;
+    END LOOP;
+END;
+$$;
+
+CREATE UNIQUE INDEX idx_ledger_order_accruals ON ledger_transactions (order_id)
+    WHERE kind = 'accrual';
+-- Старые повторные начисления остаются без ссылки на заказ,
+-- новые начисления без нее записать нельзя.
+ALTER TABLE ledger_transactions ADD CONSTRAINT ledger_accrual_order
+    CHECK (kind <> 'accrual' OR order_id IS NOT NULL) NOT VALID;
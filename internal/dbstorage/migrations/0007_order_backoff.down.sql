@@ -0,0 +1,11 @@
+-- Зависшие заказы снова попадают в опрос.
+UPDATE orders SET status = 'PROCESSING' WHERE status = 'STUCK';
+
+DROP INDEX IF EXISTS idx_orders_stuck;
+DROP INDEX IF EXISTS idx_orders_pending;
+CREATE INDEX idx_orders_pending ON orders (claimed_until)
+    WHERE status IN ('NEW', 'PROCESSING');
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS attempts,
+    DROP COLUMN IF EXISTS next_check_at;
@@ -0,0 +1,11 @@
+-- Заказ без финального статуса проверяется не раньше next_check_at,
+-- интервал растет с числом попыток attempts.
+ALTER TABLE orders
+    ADD COLUMN next_check_at BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+
+DROP INDEX IF EXISTS idx_orders_pending;
+CREATE INDEX idx_orders_pending ON orders (next_check_at)
+    WHERE status IN ('NEW', 'PROCESSING');
+CREATE INDEX idx_orders_stuck ON orders (uploaded_at)
+    WHERE status = 'STUCK';
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS idempotency_keys;
+DROP INDEX IF EXISTS idx_order_logs_user_order;
+ALTER TABLE order_logs DROP COLUMN IF EXISTS duplicate;
@@ -0,0 +1,25 @@
+-- Номер заказа оплачивается баллами пользователя один раз. Повторные
+-- списания, если они успели случиться, остаются в истории, но не
+-- попадают под уникальный индекс.
+ALTER TABLE order_logs ADD COLUMN duplicate BOOLEAN NOT NULL DEFAULT false;
+UPDATE order_logs l SET duplicate = true
+WHERE l.id <> (
+    SELECT min(d.id) FROM order_logs d
+    WHERE d.user_id = l.user_id AND d.order_number = l.order_number
+);
+CREATE UNIQUE INDEX idx_order_logs_user_order ON order_logs (user_id, order_number)
+    WHERE NOT duplicate;
+
+-- Ответы на запросы с заголовком Idempotency-Key, status = 0 пока
+-- запрос выполняется.
+CREATE TABLE idempotency_keys (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status INTEGER NOT NULL DEFAULT 0,
+    content_type TEXT NOT NULL DEFAULT '',
+    body BYTEA,
+    created_at BIGINT NOT NULL
+);
+CREATE UNIQUE INDEX idx_idempotency_user_key ON idempotency_keys (user_id, key);
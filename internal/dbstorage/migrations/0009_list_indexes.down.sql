@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_order_logs_user_processed;
+DROP INDEX IF EXISTS idx_orders_user_uploaded;
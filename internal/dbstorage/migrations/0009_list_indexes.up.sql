@@ -0,0 +1,3 @@
+-- Страницы заказов и списаний пользователя выбираются по времени и id.
+CREATE INDEX idx_orders_user_uploaded ON orders (user_id, uploaded_at, id);
+CREATE INDEX idx_order_logs_user_processed ON order_logs (user_id, processed_at, id);
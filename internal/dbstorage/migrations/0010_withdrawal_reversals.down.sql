@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_order_logs_user_order;
+-- Повторная оплата отмененного заказа становится дублем.
+UPDATE order_logs l SET duplicate = true
+WHERE NOT l.duplicate AND l.id <> (
+    SELECT min(d.id) FROM order_logs d
+    WHERE d.user_id = l.user_id AND d.order_number = l.order_number AND NOT d.duplicate
+);
+CREATE UNIQUE INDEX idx_order_logs_user_order ON order_logs (user_id, order_number)
+    WHERE NOT duplicate;
+ALTER TABLE order_logs DROP COLUMN IF EXISTS reversed;
@@ -0,0 +1,29 @@
+-- Отмененное списание остается в истории, но не входит в сумму
+-- списаний и не мешает оплатить заказ снова.
+ALTER TABLE order_logs ADD COLUMN reversed BOOLEAN NOT NULL DEFAULT false;
+
+DO $$
+DECLARE
+    rec RECORD;
+BEGIN
+    FOR rec IN
+        SELECT t.id, t.reference FROM ledger_transactions t
+        WHERE t.kind = 'withdrawal'
+            AND EXISTS (SELECT 1 FROM ledger_transactions r WHERE r.reversal_of = t.id)
+        ORDER BY t.id
+    LOOP
+        UPDATE order_logs SET reversed = true WHERE id = (
+            SELECT l.id FROM order_logs l
+            JOIN accounts a ON a.user_id = l.user_id
+            JOIN ledger_entries e ON e.account_id = a.id
+            WHERE e.transaction_id = rec.id AND l.order_number = rec.reference AND NOT l.reversed
+            ORDER BY l.duplicate DESC, l.id
+            LIMIT 1
+        );
+    END LOOP;
+END;
+$$;
+
+DROP INDEX idx_order_logs_user_order;
+CREATE UNIQUE INDEX idx_order_logs_user_order ON order_logs (user_id, order_number)
+    WHERE NOT duplicate AND NOT reversed;
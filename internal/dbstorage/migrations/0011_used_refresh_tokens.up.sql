@@ -0,0 +1,7 @@
+-- Хеши refresh-токенов, замененных ротацией. Повторное предъявление
+-- такого токена отзывает его сессию.
+CREATE TABLE used_refresh_tokens (
+    hash TEXT PRIMARY KEY,
+    session_id BIGINT NOT NULL REFERENCES sessions (id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX idx_used_refresh_tokens_session_id ON used_refresh_tokens (session_id);
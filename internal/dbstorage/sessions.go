@@ -0,0 +1,146 @@
+package dbstorage
+
+import (
+	"context"
+	"time"
+
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"gorm.io/gorm"
+)
+
+func (ds *DBStorage) CreateSession(ctx context.Context, login string, session models.Session) (*models.Session, error) {
+	db := ds.DB.WithContext(ctx)
+	user, err := ds.GetUser(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+	session.UserID = user.ID
+	session.LastUsedAt = time.Now().Unix()
+	err = db.Create(&session).Error
+	return &session, err
+}
+
+func (ds *DBStorage) GetSession(ctx context.Context, id uint) (*models.Session, error) {
+	db := ds.DB.WithContext(ctx)
+	var session models.Session
+	err := db.Where(
+		"id = ? AND revoked_at = 0 AND expires_at > ?", id, time.Now().Unix(),
+	).Take(&session).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, storage.ErrSessionNotFound
+	}
+	return &session, err
+}
+
+// RefreshSession replaces refresh token hash of active session and
+// returns session owner with updated session. Replaced hash is kept,
+// its reuse revokes the session and returns storage.ErrInvalidRefreshToken.
+func (ds *DBStorage) RefreshSession(ctx context.Context, refreshHash string, session models.Session) (*models.User, *models.Session, error) {
+	db := ds.DB.WithContext(ctx)
+	var user models.User
+	var dbSession models.Session
+	reused := false
+	// transaction start
+	err := db.Transaction(
+		func(tx *gorm.DB) error {
+			now := time.Now().Unix()
+			if err := tx.Where(
+				"refresh_hash = ? AND revoked_at = 0 AND expires_at > ?", refreshHash, now,
+			).Take(&dbSession).Error; err != nil {
+				if err != gorm.ErrRecordNotFound {
+					return err
+				}
+				var used models.UsedRefreshToken
+				if err := tx.Take(&used, "hash = ?", refreshHash).Error; err != nil {
+					if err == gorm.ErrRecordNotFound {
+						return storage.ErrInvalidRefreshToken
+					}
+					return err
+				}
+				// отзыв должен сохраниться, поэтому транзакция завершается без ошибки
+				reused = true
+				return tx.Model(&models.Session{}).Where(
+					"id = ? AND revoked_at = 0", used.SessionID,
+				).Update("revoked_at", now).Error
+			}
+			dbSession.RefreshHash = session.RefreshHash
+			dbSession.UserAgent = session.UserAgent
+			dbSession.IPAddress = session.IPAddress
+			dbSession.ExpiresAt = session.ExpiresAt
+			dbSession.LastUsedAt = now
+			// Условие на старый хеш не дает обновить сессию дважды одним токеном.
+			result := tx.Model(&models.Session{}).Where(
+				"id = ? AND refresh_hash = ?", dbSession.ID, refreshHash,
+			).Updates(map[string]interface{}{
+				"refresh_hash": dbSession.RefreshHash,
+				"user_agent":   dbSession.UserAgent,
+				"ip_address":   dbSession.IPAddress,
+				"expires_at":   dbSession.ExpiresAt,
+				"last_used_at": dbSession.LastUsedAt,
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return storage.ErrInvalidRefreshToken
+			}
+			if err := tx.Create(&models.UsedRefreshToken{Hash: refreshHash, SessionID: dbSession.ID}).Error; err != nil {
+				return err
+			}
+			return tx.Select("id", "login", "role").Take(&user, dbSession.UserID).Error
+		},
+	)
+	// transaction end
+	if err == nil && reused {
+		err = storage.ErrInvalidRefreshToken
+	}
+	return &user, &dbSession, err
+}
+
+// GetSessions returns active sessions of user.
+func (ds *DBStorage) GetSessions(ctx context.Context, login string) ([]models.Session, error) {
+	db := ds.DB.WithContext(ctx)
+	sessions := make([]models.Session, 0)
+	user, err := ds.GetUser(ctx, login)
+	if err != nil {
+		return sessions, err
+	}
+
+	err = db.Where(
+		"user_id = ? AND revoked_at = 0 AND expires_at > ?", user.ID, time.Now().Unix(),
+	).Order("id").Find(&sessions).Error
+	return sessions, err
+}
+
+func (ds *DBStorage) RevokeSession(ctx context.Context, login string, id uint) error {
+	db := ds.DB.WithContext(ctx)
+	user, err := ds.GetUser(ctx, login)
+	if err != nil {
+		return err
+	}
+
+	result := db.Model(&models.Session{}).Where(
+		"id = ? AND user_id = ? AND revoked_at = 0", id, user.ID,
+	).Update("revoked_at", time.Now().Unix())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return storage.ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeSessions revokes all sessions of user except session with exceptID.
+func (ds *DBStorage) RevokeSessions(ctx context.Context, login string, exceptID uint) error {
+	db := ds.DB.WithContext(ctx)
+	user, err := ds.GetUser(ctx, login)
+	if err != nil {
+		return err
+	}
+
+	return db.Model(&models.Session{}).Where(
+		"user_id = ? AND id <> ? AND revoked_at = 0", user.ID, exceptID,
+	).Update("revoked_at", time.Now().Unix()).Error
+}
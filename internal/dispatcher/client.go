@@ -0,0 +1,128 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/hrapovd1/loyalty-account/internal/metrics"
+	"github.com/hrapovd1/loyalty-account/internal/tracing"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ErrOrderNotRegistered = errors.New("order is not registered in accrual system")
+
+// AccrualClient requests calculation status of order from accrual system.
+type AccrualClient interface {
+	GetOrder(ctx context.Context, number string) (*types.AccrualAnswer, error)
+}
+
+// RateLimitError is returned when accrual system answers 429,
+// Limit is number of requests per minute or 0 if it is unknown.
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Limit      int
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("accrual system rate limit exceeded, retry after %v", e.RetryAfter)
+}
+
+// StatusError is returned on unexpected status of accrual system answer.
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("accrual system returned status %d: %s", e.Code, e.Body)
+}
+
+// HTTPAccrualClient is AccrualClient of accrual system HTTP API.
+type HTTPAccrualClient struct {
+	address string
+	client  *resty.Client
+}
+
+var _ AccrualClient = (*HTTPAccrualClient)(nil)
+
+// NewHTTPAccrualClient returns client of accrual system at address,
+// address without scheme is requested by http.
+func NewHTTPAccrualClient(address string) *HTTPAccrualClient {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return &HTTPAccrualClient{
+		address: strings.TrimSuffix(address, "/"),
+		client:  resty.New(),
+	}
+}
+
+// GetOrder returns calculation status of order, ErrOrderNotRegistered
+// when order is unknown to accrual system, *RateLimitError on 429
+// and *StatusError on other unexpected answers.
+func (c *HTTPAccrualClient) GetOrder(ctx context.Context, number string) (*types.AccrualAnswer, error) {
+	// Ответ без начисления не содержит поля accrual, поэтому
+	// структура не должна переиспользоваться между заказами.
+	answer := types.AccrualAnswer{}
+	ctx, span := tracing.Tracer().Start(ctx, "GET /api/orders/{number}",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("order.number", number)),
+	)
+	defer span.End()
+
+	req := c.client.R().SetContext(ctx).SetResult(&answer)
+	// контекст трассировки передается системе начисления
+	tracing.Inject(ctx, req.Header)
+	resp, err := req.Get(c.address + "/api/orders/" + url.PathEscape(number))
+	if err != nil {
+		metrics.AccrualResponses.WithLabelValues(metrics.AccrualResponseError).Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	metrics.AccrualResponses.WithLabelValues(strconv.Itoa(resp.StatusCode())).Inc()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode()))
+	if resp.StatusCode() != http.StatusOK && resp.StatusCode() != http.StatusNoContent {
+		span.SetStatus(codes.Error, resp.Status())
+	}
+	switch resp.StatusCode() {
+	case http.StatusOK:
+		return &answer, nil
+	case http.StatusNoContent:
+		return nil, ErrOrderNotRegistered
+	case http.StatusTooManyRequests:
+		rateErr := &RateLimitError{
+			RetryAfter: parseRetryAfter(resp.Header().Get("Retry-After"), time.Now()),
+		}
+		if n, ok := parseRateLimit(resp.String()); ok {
+			rateErr.Limit = n
+		}
+		return nil, rateErr
+	default:
+		return nil, &StatusError{Code: resp.StatusCode(), Body: resp.String()}
+	}
+}
+
+// Ping checks that accrual system is reachable, any answer except
+// server error means it is.
+func (c *HTTPAccrualClient) Ping(ctx context.Context) error {
+	resp, err := c.client.R().SetContext(ctx).Get(c.address + "/")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() >= http.StatusInternalServerError {
+		return &StatusError{Code: resp.StatusCode(), Body: resp.String()}
+	}
+	return nil
+}
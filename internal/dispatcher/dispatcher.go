@@ -2,93 +2,327 @@ package dispatcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/go-resty/resty/v2"
-	"github.com/hrapovd1/loyalty-account/internal/dbstorage"
+	"github.com/hrapovd1/loyalty-account/internal/logging"
+	"github.com/hrapovd1/loyalty-account/internal/metrics"
 	"github.com/hrapovd1/loyalty-account/internal/models"
-	"github.com/hrapovd1/loyalty-account/internal/types"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/tracing"
+	"github.com/hrapovd1/loyalty-account/internal/usecase"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	statNew        = "NEW"
-	statProcessing = "PROCESSING"
-	checkPause     = 5 * time.Second
+	defaultWorkers        = 4
+	defaultPollInterval   = 5 * time.Second
+	defaultRequestTimeout = 3 * time.Second
+	defaultBatchSize      = 100
+	defaultLease          = time.Minute
+	defaultBackoffMin     = 5 * time.Second
+	defaultBackoffMax     = 10 * time.Minute
+	defaultMaxAge         = 72 * time.Hour
+	dbTimeout             = time.Second
 )
 
+// Dispatcher polls accrual system for orders in NEW and PROCESSING
+// status by pool of Workers, zero values of settings mean defaults.
+// Orders are leased to InstanceID for Lease, so several replicas
+// don't poll the same orders, lease is renewed right before request of
+// every order, as waiting for rate limit may outlast it. Order without final status is checked
+// again after exponential backoff from BackoffMin to BackoffMax and
+// becomes STUCK when it is older than MaxAge. Client is used to request
+// accrual system, by default it is HTTP client of AccrualAddress.
 type Dispatcher struct {
-	Storage        *dbstorage.DBStorage
-	Logger         *log.Logger
+	Storage        storage.Storage
+	Logger         *slog.Logger
 	AccrualAddress string
+	Client         AccrualClient
+	Workers        int
+	PollInterval   time.Duration
+	RequestTimeout time.Duration
+	BatchSize      int
+	Lease          time.Duration
+	InstanceID     string
+	BackoffMin     time.Duration
+	BackoffMax     time.Duration
+	MaxAge         time.Duration
+
+	limiter   *limiter
+	rndMu     sync.Mutex
+	rnd       *rand.Rand
+	heartbeat atomic.Int64
 }
 
-func (disp Dispatcher) Run(ctx context.Context) {
-	answer := types.AccrualAnswer{}
-	checkNew := true
-	client := resty.New()
+// Run polls orders until ctx is done, every PollInterval batch of orders
+// is claimed in storage and sent to workers, next poll starts when
+// all orders of previous one are checked. When ctx is done, requests to
+// accrual system are abandoned and their orders are checked again after
+// lease, started storage updates are finished. Run returns when all
+// workers are stopped.
+func (disp *Dispatcher) Run(ctx context.Context) {
+	if disp.Workers < 1 {
+		disp.Workers = defaultWorkers
+	}
+	if disp.PollInterval <= 0 {
+		disp.PollInterval = defaultPollInterval
+	}
+	if disp.RequestTimeout <= 0 {
+		disp.RequestTimeout = defaultRequestTimeout
+	}
+	if disp.BatchSize < 1 {
+		disp.BatchSize = defaultBatchSize
+	}
+	if disp.Lease <= 0 {
+		disp.Lease = defaultLease
+	}
+	if disp.InstanceID == "" {
+		hostname, _ := os.Hostname()
+		disp.InstanceID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if disp.BackoffMin <= 0 {
+		disp.BackoffMin = defaultBackoffMin
+	}
+	if disp.BackoffMax < disp.BackoffMin {
+		disp.BackoffMax = defaultBackoffMax
+	}
+	if disp.MaxAge <= 0 {
+		disp.MaxAge = defaultMaxAge
+	}
+	if disp.Logger == nil {
+		disp.Logger = slog.Default()
+	}
+	if disp.Client == nil {
+		disp.Client = NewHTTPAccrualClient(disp.AccrualAddress)
+	}
+	disp.limiter = &limiter{beat: disp.beat}
+	disp.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	disp.beat()
+
+	jobs := make(chan models.Order)
+	var batch, workers sync.WaitGroup
+	for i := 0; i < disp.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for order := range jobs {
+				disp.checkOrder(ctx, order)
+				disp.beat()
+				batch.Done()
+			}
+		}()
+	}
+	defer workers.Wait()
+	defer close(jobs)
+
+	ticker := time.NewTicker(disp.PollInterval)
+	defer ticker.Stop()
 	for {
+		disp.markStuck(ctx)
+		disp.observeBacklog(ctx)
+		orders := disp.claimOrders(ctx)
+		if len(orders) > 0 {
+			disp.Logger.Debug("orders claimed", "count", len(orders))
+		}
+	enqueue:
+		for _, order := range orders {
+			batch.Add(1)
+			select {
+			case jobs <- order:
+			case <-ctx.Done():
+				batch.Done()
+				break enqueue
+			}
+		}
+		batch.Wait()
+		disp.beat()
+
 		select {
 		case <-ctx.Done():
 			return
-		default:
+		case <-ticker.C:
 		}
+	}
+}
 
-		clientCTX, cltCancel := context.WithTimeout(ctx, 3*time.Second)
-		defer cltCancel()
-		dbCTX, dbCancel := context.WithTimeout(ctx, time.Second)
-		defer dbCancel()
+// Heartbeat returns time when Run started, completed last iteration,
+// checked order or waited for rate limit of accrual system, it is zero
+// before Run.
+func (disp *Dispatcher) Heartbeat() time.Time {
+	nanos := disp.heartbeat.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
 
-		var status string
-		if checkNew {
-			status = statNew
-		} else {
-			status = statProcessing
+func (disp *Dispatcher) beat() {
+	disp.heartbeat.Store(time.Now().UnixNano())
+}
+
+// markStuck stops polling of orders older than MaxAge.
+func (disp *Dispatcher) markStuck(ctx context.Context) {
+	dbCTX, dbCancel := context.WithTimeout(ctx, dbTimeout)
+	defer dbCancel()
+	count, err := disp.Storage.DispatchMarkStuck(dbCTX, time.Now().Add(-disp.MaxAge).Unix())
+	if err != nil {
+		if ctx.Err() == nil {
+			disp.Logger.Error("dispatcher storage error", logging.Err(err))
 		}
-		orderNumbers, err := disp.Storage.DispatchGetOrders(dbCTX, status)
+		return
+	}
+	if count > 0 {
+		disp.Logger.Warn("orders marked as stuck", "count", count, "max_age", disp.MaxAge.String())
+	}
+}
+
+// observeBacklog updates metrics of orders waiting for accrual.
+func (disp *Dispatcher) observeBacklog(ctx context.Context) {
+	for _, status := range []string{models.OrderNew, models.OrderProcessing} {
+		dbCTX, dbCancel := context.WithTimeout(ctx, dbTimeout)
+		numbers, err := disp.Storage.DispatchGetOrders(dbCTX, status)
+		dbCancel()
 		if err != nil {
-			disp.Logger.Print(err)
+			if ctx.Err() == nil {
+				disp.Logger.Error("dispatcher storage error", logging.Err(err))
+			}
+			continue
 		}
+		metrics.DispatcherBacklog.WithLabelValues(status).Set(float64(len(numbers)))
+	}
+}
 
-		if len(orderNumbers) == 0 {
-			checkNew = !checkNew
-			time.Sleep(checkPause)
-			continue
+// claimOrders leases batch of due NEW and PROCESSING orders to instance.
+func (disp *Dispatcher) claimOrders(ctx context.Context) []models.Order {
+	dbCTX, dbCancel := context.WithTimeout(ctx, dbTimeout)
+	defer dbCancel()
+	orders, err := disp.Storage.DispatchClaimOrders(dbCTX, disp.InstanceID, disp.Lease, disp.BatchSize)
+	if err != nil && ctx.Err() == nil {
+		disp.Logger.Error("dispatcher storage error", logging.Err(err))
+	}
+	return orders
+}
+
+// renewClaim extends lease of order before request to accrual system,
+// it returns false if order can't be checked now: lease expired while
+// order waited for rate limit and order is claimed by another instance
+// or it got final status.
+func (disp *Dispatcher) renewClaim(ctx context.Context, order models.Order) bool {
+	dbCTX, dbCancel := context.WithTimeout(ctx, dbTimeout)
+	defer dbCancel()
+	err := disp.Storage.DispatchRenewClaim(dbCTX, order.Number, disp.InstanceID, disp.Lease)
+	if errors.Is(err, storage.ErrClaimLost) {
+		disp.Logger.DebugContext(ctx, "order lease lost", "order", order.Number)
+		return false
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			disp.Logger.ErrorContext(ctx, "can't renew order lease", "order", order.Number, logging.Err(err))
 		}
-		disp.Logger.Printf("Dispatcher, orderNums = %+v, type = %T", orderNumbers, orderNumbers)
-		for _, order := range orderNumbers {
-			resp, err := client.R().
-				SetContext(clientCTX).
-				SetResult(&answer).
-				Get(fmt.Sprint(disp.AccrualAddress, "/api/orders/", order))
-			if err != nil {
-				disp.Logger.Print(err)
-				continue
-			}
-			if resp.StatusCode() != http.StatusOK {
-				disp.Logger.Printf("For order number = %v, accrual system returned status: %v", order, resp.StatusCode())
-				disp.Logger.Printf("Answer = '%+v'", resp.String())
-				continue
-			}
-			switch answer.Status {
-			case "INVALID", "PROCESSED":
-				if err = disp.Storage.DispatchUpdateOrder(
-					dbCTX,
-					models.Order{
-						Number:  answer.OrderNumber,
-						Status:  answer.Status,
-						Accrual: answer.Accrual,
-					},
-				); err != nil {
-					disp.Logger.Print(err)
-					continue
-				}
-			case "REGISTERED", "PROCESSING":
-				continue
-			}
+		return false
+	}
+	return true
+}
+
+// backoff returns delay before next check after attempt unsuccessful
+// checks, it doubles from BackoffMin up to BackoffMax and is randomized
+// in [d/2, d] range, so orders uploaded together are spread in time.
+func (disp *Dispatcher) backoff(attempt int) time.Duration {
+	delay := disp.BackoffMin
+	for i := 1; i < attempt && delay < disp.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > disp.BackoffMax {
+		delay = disp.BackoffMax
+	}
+	disp.rndMu.Lock()
+	defer disp.rndMu.Unlock()
+	return delay/2 + time.Duration(disp.rnd.Int63n(int64(delay/2)+1))
+}
+
+// reschedule postpones next check of order by backoff, not empty
+// status is saved with it.
+func (disp *Dispatcher) reschedule(ctx context.Context, order models.Order, status string) {
+	attempts := order.Attempts + 1
+	dbCTX, dbCancel := detachedContext(ctx)
+	defer dbCancel()
+	if err := disp.Storage.DispatchRescheduleOrder(dbCTX, models.Order{
+		Number:      order.Number,
+		Status:      status,
+		Attempts:    attempts,
+		NextCheckAt: time.Now().Add(disp.backoff(attempts)).Unix(),
+	}); err != nil {
+		disp.Logger.ErrorContext(ctx, "can't reschedule order", "order", order.Number, logging.Err(err))
+	}
+}
+
+// checkOrder requests order status from accrual system and saves
+// final status with accrual. Requests are paced by shared limiter,
+// 429 answer pauses all workers and sets limit from its body.
+func (disp *Dispatcher) checkOrder(ctx context.Context, order models.Order) {
+	if err := disp.limiter.Wait(ctx); err != nil {
+		return
+	}
+	if !disp.renewClaim(ctx, order) {
+		return
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "dispatcher.checkOrder",
+		trace.WithAttributes(attribute.String("order.number", order.Number)),
+	)
+	defer span.End()
+
+	clientCTX, cltCancel := context.WithTimeout(ctx, disp.RequestTimeout)
+	defer cltCancel()
+
+	answer, err := disp.Client.GetOrder(clientCTX, order.Number)
+	var rateErr *RateLimitError
+	if errors.As(err, &rateErr) {
+		disp.limiter.Pause(time.Now().Add(rateErr.RetryAfter))
+		if rateErr.Limit > 0 {
+			disp.limiter.SetLimit(rateErr.Limit)
 		}
-		checkNew = !checkNew
+		disp.Logger.WarnContext(ctx, "accrual system rate limit exceeded",
+			"retry_after", rateErr.RetryAfter.String(), "limit_per_minute", rateErr.Limit,
+		)
+		return
 	}
+	if err != nil {
+		// при остановке запрос брошен, заказ проверит следующий экземпляр после аренды
+		if ctx.Err() != nil {
+			return
+		}
+		disp.Logger.WarnContext(ctx, "accrual request failed", "order", order.Number, logging.Err(err))
+		disp.reschedule(ctx, order, "")
+		return
+	}
+	switch answer.Status {
+	case models.OrderInvalid, models.OrderProcessed:
+		dbCTX, dbCancel := detachedContext(ctx)
+		defer dbCancel()
+		answer.OrderNumber = order.Number
+		if err = usecase.ApplyAccrual(dbCTX, disp.Storage, *answer, 0); err != nil {
+			disp.Logger.ErrorContext(ctx, "can't update order", "order", order.Number, logging.Err(err))
+		}
+	case models.OrderRegistered, models.OrderProcessing:
+		// расчет еще не завершен, заказ принят системой начисления
+		disp.reschedule(ctx, order, models.OrderProcessing)
+	default:
+		disp.Logger.WarnContext(ctx, "accrual system returned unknown status", "order", order.Number, "status", answer.Status)
+		disp.reschedule(ctx, order, "")
+	}
+}
+
+// detachedContext returns context of storage update of checked order,
+// it keeps values of ctx, but is not canceled on stop of dispatcher,
+// so the update is finished.
+func detachedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), dbTimeout)
 }
@@ -0,0 +1,53 @@
+package dispatcher
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	disp := &Dispatcher{
+		BackoffMin: 5 * time.Second,
+		BackoffMax: 10 * time.Minute,
+		rnd:        rand.New(rand.NewSource(1)),
+	}
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{0, 5 * time.Second},
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{7, 320 * time.Second},
+		{8, 10 * time.Minute},
+		{9, 10 * time.Minute},
+		{1000, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		// результат случаен, проверяется диапазон на многих попытках
+		for i := 0; i < 1000; i++ {
+			got := disp.backoff(tt.attempt)
+			if got < tt.delay/2 || got > tt.delay {
+				t.Fatalf("backoff(%d) = %v, want in [%v, %v]", tt.attempt, got, tt.delay/2, tt.delay)
+			}
+			if got > disp.BackoffMax {
+				t.Fatalf("backoff(%d) = %v, more than BackoffMax %v", tt.attempt, got, disp.BackoffMax)
+			}
+		}
+	}
+}
+
+func TestBackoffMaxNotPowerOfMin(t *testing.T) {
+	disp := &Dispatcher{
+		BackoffMin: 3 * time.Second,
+		BackoffMax: 10 * time.Second,
+		rnd:        rand.New(rand.NewSource(1)),
+	}
+	for attempt := 1; attempt < 100; attempt++ {
+		if got := disp.backoff(attempt); got > disp.BackoffMax || got < disp.BackoffMin/2 {
+			t.Fatalf("backoff(%d) = %v, want in [%v, %v]", attempt, got, disp.BackoffMin/2, disp.BackoffMax)
+		}
+	}
+}
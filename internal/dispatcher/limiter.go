@@ -0,0 +1,129 @@
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultRetryAfter is a pause after 429 answer without Retry-After header.
+const defaultRetryAfter = time.Minute
+
+// beatInterval is how often waiting worker reports that it is alive.
+const beatInterval = time.Second
+
+var rateLimitRe = regexp.MustCompile(`(\d+) requests per minute`)
+
+// limiter is a token bucket shared by all workers, it also keeps global
+// pause requested by accrual system. Zero limiter is unlimited.
+// Not nil beat is called while Wait blocks, so waiting for limit
+// isn't taken as hang of dispatcher.
+type limiter struct {
+	beat func()
+
+	mu          sync.Mutex
+	rate        float64 // tokens per second, 0 means no limit
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+// Wait blocks until request is allowed or ctx is done, calling beat
+// every beatInterval.
+func (l *limiter) Wait(ctx context.Context) error {
+	for {
+		delay := l.reserve(time.Now())
+		if delay <= 0 {
+			return nil
+		}
+		if l.beat != nil {
+			l.beat()
+			// долгое ожидание делится на части, между ними бьется пульс
+			delay = min(delay, beatInterval)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve takes token and returns 0 or returns time to wait for it.
+func (l *limiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+	if l.rate == 0 {
+		return 0
+	}
+	// Емкость корзины один запрос, так запросы идут равномерно
+	// и за любую минуту их не больше установленного лимита.
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > 1 {
+		l.tokens = 1
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Pause stops all requests until time until.
+func (l *limiter) Pause(until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+		// после паузы лимит начинает отсчитываться заново
+		l.tokens = 0
+		l.last = until
+	}
+}
+
+// SetLimit sets allowed number of requests per minute.
+func (l *limiter) SetLimit(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = float64(perMinute) / 60
+}
+
+// parseRetryAfter returns pause from Retry-After header, which is
+// either number of seconds or HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if date.After(now) {
+			return date.Sub(now)
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
+
+// parseRateLimit finds N in "No more than N requests per minute allowed".
+func parseRateLimit(body string) (int, bool) {
+	match := rateLimitRe.FindStringSubmatch(body)
+	if match == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(match[1])
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,157 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hrapovd1/loyalty-account/internal/fakeaccrual"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"seconds", "120", 2 * time.Minute},
+		{"zero", "0", 0},
+		{"empty", "", defaultRetryAfter},
+		{"negative", "-5", defaultRetryAfter},
+		{"garbage", "soon", defaultRetryAfter},
+		{"fraction", "1.5", defaultRetryAfter},
+		{"http date", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{"rfc850 date", now.Add(time.Hour).Format(time.RFC850), time.Hour},
+		{"ansi c date", now.Add(time.Minute).Format(time.ANSIC), time.Minute},
+		{"past date", now.Add(-time.Hour).Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value, now); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		body   string
+		want   int
+		wantOK bool
+	}{
+		{"No more than 60 requests per minute allowed", 60, true},
+		{"No more than 1 requests per minute allowed\n", 1, true},
+		{"No more than 0 requests per minute allowed", 0, false},
+		{"Too many requests", 0, false},
+		{"", 0, false},
+		{"No more than 99999999999999999999 requests per minute allowed", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRateLimit(tt.body)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRateLimit(%q) = %d, %v, want %d, %v", tt.body, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	t0 := time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+	l := &limiter{}
+
+	// Без лимита запросы не ждут.
+	for i := 0; i < 3; i++ {
+		if delay := l.reserve(t0); delay != 0 {
+			t.Fatalf("unlimited reserve = %v, want 0", delay)
+		}
+	}
+
+	l.SetLimit(60)
+	steps := []struct {
+		at   time.Duration
+		want time.Duration
+	}{
+		{0, 0},
+		{0, time.Second},
+		{500 * time.Millisecond, 500 * time.Millisecond},
+		{time.Second, 0},
+		// корзина вмещает один запрос, простой не копит запросы
+		{10 * time.Second, 0},
+		{10 * time.Second, time.Second},
+	}
+	for _, step := range steps {
+		if delay := l.reserve(t0.Add(step.at)); delay != step.want {
+			t.Errorf("reserve at +%v = %v, want %v", step.at, delay, step.want)
+		}
+	}
+
+	// Пауза останавливает все запросы, более ранняя пауза ее не сокращает.
+	l.Pause(t0.Add(30 * time.Second))
+	l.Pause(t0.Add(20 * time.Second))
+	if delay := l.reserve(t0.Add(12 * time.Second)); delay != 18*time.Second {
+		t.Errorf("reserve in pause = %v, want 18s", delay)
+	}
+	// после паузы лимит отсчитывается заново
+	if delay := l.reserve(t0.Add(30 * time.Second)); delay != time.Second {
+		t.Errorf("reserve at end of pause = %v, want 1s", delay)
+	}
+	if delay := l.reserve(t0.Add(31 * time.Second)); delay != 0 {
+		t.Errorf("reserve after pause = %v, want 0", delay)
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	var beats atomic.Int32
+	l := &limiter{beat: func() { beats.Add(1) }}
+	if err := l.Wait(context.Background()); err != nil || beats.Load() != 0 {
+		t.Errorf("Wait without limit = %v with %d beats, want nil without beats", err, beats.Load())
+	}
+	l.Pause(time.Now().Add(time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), beatInterval+100*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait in pause = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// пульс бьется в начале ожидания и после каждого beatInterval
+	if got := beats.Load(); got != 2 {
+		t.Errorf("Wait in pause beats %d times, want 2", got)
+	}
+}
+
+func TestRateLimitError(t *testing.T) {
+	const number = "12345678903"
+	tests := []struct {
+		name      string
+		rateLimit int
+		want      RateLimitError
+	}{
+		{"with limit", 60, RateLimitError{RetryAfter: 10 * time.Second, Limit: 60}},
+		{"without limit", 0, RateLimitError{RetryAfter: 10 * time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(fakeaccrual.NewServer(fakeaccrual.Script{
+				RateLimit: tt.rateLimit,
+				Orders: map[string][]fakeaccrual.Step{
+					number: {{Code: http.StatusTooManyRequests, RetryAfter: 10}},
+				},
+			}))
+			defer srv.Close()
+
+			_, err := NewHTTPAccrualClient(srv.URL).GetOrder(context.Background(), number)
+			var rateErr *RateLimitError
+			if !errors.As(err, &rateErr) {
+				t.Fatalf("GetOrder error = %v, want *RateLimitError", err)
+			}
+			if *rateErr != tt.want {
+				t.Errorf("GetOrder error = %+v, want %+v", *rateErr, tt.want)
+			}
+		})
+	}
+}
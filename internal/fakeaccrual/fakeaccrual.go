@@ -0,0 +1,256 @@
+// Package fakeaccrual is a scriptable accrual system for local end-to-end
+// scenarios, it serves GET /api/orders/{number} like real one and can be
+// run by "gophermart fake-accrual" or embedded into httptest.Server.
+package fakeaccrual
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/money"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+)
+
+// Duration is time.Duration encoded in JSON as string like "1.5s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	value, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+// Step is one answer for order. Zero Code means 200 with Status and
+// Accrual, 204 means order is not registered, 429 is answered with
+// RetryAfter seconds and any other code with error text.
+type Step struct {
+	Status     string       `json:"status,omitempty"`
+	Accrual    money.Amount `json:"accrual,omitempty"`
+	Code       int          `json:"code,omitempty"`
+	Delay      Duration     `json:"delay,omitempty"`
+	RetryAfter int          `json:"retry_after,omitempty"`
+}
+
+// Script sets answers of server. Every request of order takes next step
+// of its script, the last step is repeated. Orders without own script
+// use Default, with empty Default they are not registered. RateLimit is
+// number of requests per minute, 0 means no limit.
+type Script struct {
+	RateLimit int               `json:"rate_limit,omitempty"`
+	Default   []Step            `json:"default,omitempty"`
+	Orders    map[string][]Step `json:"orders,omitempty"`
+}
+
+// DefaultScript registers every order, calculates it on the next
+// request and accrues 500 points.
+var DefaultScript = Script{
+	Default: []Step{
+		{Status: models.OrderRegistered},
+		{Status: models.OrderProcessing},
+		{Status: models.OrderProcessed, Accrual: 50000}, // 500 баллов
+	},
+}
+
+// Server is http.Handler of fake accrual system, it must be created
+// by NewServer.
+type Server struct {
+	router *chi.Mux
+
+	mu          sync.Mutex
+	script      Script
+	positions   map[string]int
+	requests    map[string]int
+	windowStart time.Time
+	windowCount int
+}
+
+// NewServer returns server answering by script.
+func NewServer(script Script) *Server {
+	srv := &Server{
+		script:    script,
+		positions: make(map[string]int),
+		requests:  make(map[string]int),
+	}
+	if srv.script.Orders == nil {
+		srv.script.Orders = make(map[string][]Step)
+	}
+
+	srv.router = chi.NewRouter()
+	srv.router.Get("/api/orders/{number}", srv.getOrder)
+	// Управление сценарием во время работы.
+	srv.router.Put("/fake/orders/{number}", srv.putOrder)
+	srv.router.Get("/fake/requests", srv.getRequests)
+	return srv
+}
+
+// LoadScript reads script from JSON file.
+func LoadScript(path string) (Script, error) {
+	var script Script
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return script, err
+	}
+	if err := json.Unmarshal(data, &script); err != nil {
+		return script, fmt.Errorf("%s: %w", path, err)
+	}
+	return script, nil
+}
+
+func (srv *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	srv.router.ServeHTTP(rw, r)
+}
+
+// SetOrder replaces script of order and starts it from the first step.
+func (srv *Server) SetOrder(number string, steps []Step) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.script.Orders[number] = steps
+	delete(srv.positions, number)
+}
+
+// Requests returns number of requests of order status.
+func (srv *Server) Requests(number string) int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	return srv.requests[number]
+}
+
+// next returns step for request of order, ok is false when order has
+// no script. Request over rate limit gets 429 step.
+func (srv *Server) next(number string, now time.Time) (Step, bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.requests[number]++
+	if srv.script.RateLimit > 0 {
+		if now.Sub(srv.windowStart) >= time.Minute {
+			srv.windowStart, srv.windowCount = now, 0
+		}
+		srv.windowCount++
+		if srv.windowCount > srv.script.RateLimit {
+			retryAfter := srv.windowStart.Add(time.Minute).Sub(now)
+			return Step{
+				Code:       http.StatusTooManyRequests,
+				RetryAfter: int((retryAfter + time.Second - 1) / time.Second),
+			}, true
+		}
+	}
+
+	steps, ok := srv.script.Orders[number]
+	if !ok {
+		steps = srv.script.Default
+	}
+	if len(steps) == 0 {
+		return Step{}, false
+	}
+	pos := srv.positions[number]
+	if pos < len(steps)-1 {
+		srv.positions[number] = pos + 1
+	}
+	return steps[pos], true
+}
+
+func (srv *Server) getOrder(rw http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+	step, ok := srv.next(number, time.Now())
+	if !ok {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if step.Delay > 0 {
+		timer := time.NewTimer(time.Duration(step.Delay))
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+
+	switch step.Code {
+	case 0, http.StatusOK:
+		answer := types.AccrualAnswer{
+			OrderNumber: number,
+			Status:      step.Status,
+			Accrual:     step.Accrual,
+		}
+		body, err := json.Marshal(answer)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		if _, err := rw.Write(body); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+		}
+	case http.StatusNoContent:
+		rw.WriteHeader(http.StatusNoContent)
+	case http.StatusTooManyRequests:
+		rw.Header().Set("Retry-After", strconv.Itoa(step.RetryAfter))
+		limit := srv.rateLimit()
+		if limit > 0 {
+			http.Error(rw, fmt.Sprintf("No more than %d requests per minute allowed", limit), http.StatusTooManyRequests)
+			return
+		}
+		http.Error(rw, "Too many requests", http.StatusTooManyRequests)
+	default:
+		http.Error(rw, http.StatusText(step.Code), step.Code)
+	}
+}
+
+func (srv *Server) rateLimit() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	return srv.script.RateLimit
+}
+
+// putOrder sets script of order from JSON array of steps.
+func (srv *Server) putOrder(rw http.ResponseWriter, r *http.Request) {
+	var steps []Step
+	if err := json.NewDecoder(r.Body).Decode(&steps); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	srv.SetOrder(chi.URLParam(r, "number"), steps)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// getRequests returns number of status requests by order.
+func (srv *Server) getRequests(rw http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	body, err := json.Marshal(srv.requests)
+	srv.mu.Unlock()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if _, err := rw.Write(body); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hrapovd1/loyalty-account/internal/auth"
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+	"github.com/hrapovd1/loyalty-account/internal/usecase"
+)
+
+// AdminGetUser handler return user account info with balance.
+func (app *AppHandler) AdminGetUser(rw http.ResponseWriter, r *http.Request) {
+	user, err := app.Storage.GetUser(r.Context(), chi.URLParam(r, "login"))
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			app.writeError(rw, r, http.StatusNotFound, err)
+			return
+		}
+		app.internalError(rw, r, err)
+		return
+	}
+
+	balance, err := app.Storage.GetBalance(r.Context(), user.Login)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	resp, err := json.Marshal(types.UserResponse{
+		Login:   user.Login,
+		Role:    user.Role,
+		Balance: *balance,
+	})
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
+
+// AdminGetOrders handler return list of user orders.
+func (app *AppHandler) AdminGetOrders(rw http.ResponseWriter, r *http.Request) {
+	app.writeOrders(rw, r, chi.URLParam(r, "login"))
+}
+
+// AdminGetStuckOrders handler return orders, which didn't get final
+// status from accrual system in time.
+func (app *AppHandler) AdminGetStuckOrders(rw http.ResponseWriter, r *http.Request) {
+	orders, err := app.Storage.GetStuckOrders(r.Context())
+	if err != nil {
+		if errors.Is(err, storage.ErrNoOrders) {
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		app.internalError(rw, r, err)
+		return
+	}
+
+	resp, err := json.Marshal(usecase.StuckOrdersTimeFormat(orders))
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
+
+// AdminGetWithdrawals handler return list of user withdrawals.
+func (app *AppHandler) AdminGetWithdrawals(rw http.ResponseWriter, r *http.Request) {
+	app.writeWithdrawals(rw, r, chi.URLParam(r, "login"))
+}
+
+// AdminGetBalance handler return user balance.
+func (app *AppHandler) AdminGetBalance(rw http.ResponseWriter, r *http.Request) {
+	login := chi.URLParam(r, "login")
+
+	if _, err := app.Storage.GetUser(r.Context(), login); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			app.writeError(rw, r, http.StatusNotFound, err)
+			return
+		}
+		app.internalError(rw, r, err)
+		return
+	}
+
+	app.writeBalance(rw, r, login)
+}
+
+// AdminGetAdjustments handler return list of user balance adjustments.
+func (app *AppHandler) AdminGetAdjustments(rw http.ResponseWriter, r *http.Request) {
+	app.writeAdjustments(rw, r, chi.URLParam(r, "login"), true)
+}
+
+// AdminAdjustBalance POST handler credits or debits user account,
+// reason code and comment are required.
+func (app *AppHandler) AdminAdjustBalance(rw http.ResponseWriter, r *http.Request) {
+	adminLogin := r.Header.Get("Login")
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	var req types.AdjustmentRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidRequest, "Wrong body format")
+		return
+	}
+	if req.Sum == 0 || !usecase.AdjustmentReasons[req.Reason] || strings.TrimSpace(req.Comment) == "" {
+		writeProblem(rw, r, http.StatusUnprocessableEntity, CodeInvalidAdjustment, "Sum, reason and comment are required")
+		return
+	}
+
+	adjustment, err := app.Storage.AdjustBalance(
+		r.Context(),
+		chi.URLParam(r, "login"),
+		adminLogin,
+		models.Adjustment{
+			Sum:        req.Sum,
+			ReasonCode: req.Reason,
+			Comment:    req.Comment,
+		},
+	)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			app.writeError(rw, r, http.StatusNotFound, err)
+			return
+		}
+		if errors.Is(err, storage.ErrNotEnoughFunds) {
+			app.writeError(rw, r, http.StatusPaymentRequired, err)
+			return
+		}
+		app.internalError(rw, r, err)
+		return
+	}
+
+	resp, err := json.Marshal(usecase.AdjustmentsTimeFormat([]models.Adjustment{*adjustment}, true)[0])
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
+
+// AdminGetLedger handler return postings of user account.
+func (app *AppHandler) AdminGetLedger(rw http.ResponseWriter, r *http.Request) {
+	app.writeLedger(rw, r, chi.URLParam(r, "login"))
+}
+
+// AdminReverseTransaction POST handler cancels ledger transaction
+// by posting its negated entries.
+func (app *AppHandler) AdminReverseTransaction(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidRequest, "Wrong transaction id")
+		return
+	}
+
+	reversal, err := app.Storage.ReverseTransaction(r.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, storage.ErrTransactionNotFound) {
+			app.writeError(rw, r, http.StatusNotFound, err)
+			return
+		}
+		if errors.Is(err, storage.ErrAlreadyReversed) || errors.Is(err, storage.ErrNotReversible) {
+			app.writeError(rw, r, http.StatusConflict, err)
+			return
+		}
+		if errors.Is(err, storage.ErrNotEnoughFunds) {
+			app.writeError(rw, r, http.StatusPaymentRequired, err)
+			return
+		}
+		app.internalError(rw, r, err)
+		return
+	}
+
+	resp, err := json.Marshal(types.LedgerTransactionResponse{
+		ID:         reversal.ID,
+		Kind:       reversal.Kind,
+		Reference:  reversal.Reference,
+		ReversalOf: reversal.ReversalOf,
+		CreatedAt:  time.Unix(reversal.CreatedAt, 0).Format(time.RFC3339),
+	})
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
+
+// AdminSetRole PUT handler changes user role. Tokens keep role they
+// were issued with, so all sessions of user are revoked and new role is
+// applied on next login.
+func (app *AppHandler) AdminSetRole(rw http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	var req types.RoleRequest
+	if err := json.Unmarshal(body, &req); err != nil || !auth.IsValidRole(req.Role) {
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidRequest, "Wrong body format")
+		return
+	}
+
+	login := chi.URLParam(r, "login")
+	if err := app.Storage.SetUserRole(r.Context(), login, req.Role); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			app.writeError(rw, r, http.StatusNotFound, err)
+			return
+		}
+		app.internalError(rw, r, err)
+		return
+	}
+	// старая роль не должна действовать до истечения выданных токенов
+	if err := app.Storage.RevokeSessions(r.Context(), login, 0); err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write([]byte(""))
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
@@ -0,0 +1,34 @@
+package handlers_test
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/hrapovd1/loyalty-account/internal/config"
+	"github.com/hrapovd1/loyalty-account/internal/handlers"
+	"github.com/hrapovd1/loyalty-account/internal/memstorage"
+	"github.com/hrapovd1/loyalty-account/internal/openapi/contracttest"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+)
+
+func TestContract(t *testing.T) {
+	contracttest.Run(t, func(t *testing.T) (*chi.Mux, storage.Storage) {
+		store := memstorage.NewMemStorage()
+		conf := config.Config{
+			PasswordHasher:       "bcrypt",
+			JWTSecret:            "secret",
+			AccrualWebhookSecret: contracttest.WebhookSecret,
+			AccrualPushTimeout:   time.Minute,
+			IdempotencyTTL:       time.Hour,
+		}
+		app, err := handlers.NewAppHandler(conf, store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handlers.NewRouter(app), store
+	})
+}
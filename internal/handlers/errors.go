@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/hrapovd1/loyalty-account/internal/auth"
+	"github.com/hrapovd1/loyalty-account/internal/logging"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+	"github.com/hrapovd1/loyalty-account/internal/usecase"
+)
+
+// Codes of API errors. Clients tell errors apart by them, so codes are
+// part of API and are not changed.
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeInvalidQuery         = "invalid_query"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeInvalidToken         = "invalid_token"
+	CodeSessionNotFound      = "session_not_found"
+	CodeAccessDenied         = "access_denied"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeUserExists           = "user_exists"
+	CodeUserNotFound         = "user_not_found"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeInvalidRefreshToken  = "invalid_refresh_token"
+	CodeInvalidOrderNumber   = "invalid_order_number"
+	CodeOrderNotFound        = "order_not_found"
+	CodeOrderOfAnotherUser   = "order_of_another_user"
+	CodeNotEnoughFunds       = "not_enough_funds"
+	CodeOrderAlreadyPaid     = "order_already_paid"
+	CodeInvalidAdjustment    = "invalid_adjustment"
+	CodeTransactionNotFound  = "transaction_not_found"
+	CodeAlreadyReversed      = "transaction_already_reversed"
+	CodeNotReversible        = "transaction_not_reversible"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeIdempotencyInFlight  = "idempotency_request_in_progress"
+	CodeInvalidSignature     = "invalid_signature"
+	CodeSignatureExpired     = "signature_expired"
+	CodeUnknownAccrualStatus = "unknown_accrual_status"
+	CodeInternal             = "internal_error"
+)
+
+// problemTypePrefix is prefix of problem type URI, the type ends with code.
+const problemTypePrefix = "urn:gophermart:problem:"
+
+// errorCodes maps errors of storage, auth and usecase to API codes.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{storage.ErrUserAlreadyExists, CodeUserExists},
+	{storage.ErrUserNotFound, CodeUserNotFound},
+	{storage.ErrInvalidLoginPassword, CodeInvalidCredentials},
+	{storage.ErrOrderExistsAnother, CodeOrderOfAnotherUser},
+	{storage.ErrNoOrders, CodeOrderNotFound},
+	{storage.ErrNotEnoughFunds, CodeNotEnoughFunds},
+	{storage.ErrWithdrawalExists, CodeOrderAlreadyPaid},
+	{storage.ErrSessionNotFound, CodeSessionNotFound},
+	{storage.ErrInvalidRefreshToken, CodeInvalidRefreshToken},
+	{storage.ErrTransactionNotFound, CodeTransactionNotFound},
+	{storage.ErrAlreadyReversed, CodeAlreadyReversed},
+	{storage.ErrNotReversible, CodeNotReversible},
+	{auth.ErrTokenWrong, CodeInvalidToken},
+	{auth.ErrSignatureWrong, CodeInvalidSignature},
+	{auth.ErrSignatureExpired, CodeSignatureExpired},
+	{usecase.ErrUnknownAccrualStatus, CodeUnknownAccrualStatus},
+}
+
+// writeProblem answers client with error in application/problem+json
+// format of RFC 7807.
+func writeProblem(rw http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	// Problem состоит из строк и числа, ошибки сериализации не бывает.
+	body, _ := json.Marshal(types.Problem{
+		Type:      problemTypePrefix + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: logging.RequestID(r.Context()),
+	})
+	rw.Header().Set("Content-Type", "application/problem+json")
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(status)
+	_, _ = rw.Write(body)
+}
+
+// writeError answers client with problem of known error: code of the
+// error and its message without details of wrapping errors. Unknown
+// errors are internal.
+func (app *AppHandler) writeError(rw http.ResponseWriter, r *http.Request, status int, err error) {
+	for _, known := range errorCodes {
+		if errors.Is(err, known.err) {
+			writeProblem(rw, r, status, known.code, known.err.Error())
+			return
+		}
+	}
+	app.internalError(rw, r, err)
+}
+
+// internalError logs err with details of request and answers client
+// with generic problem, request ID of it helps to find the log record.
+func (app *AppHandler) internalError(rw http.ResponseWriter, r *http.Request, err error) {
+	app.Logger.ErrorContext(r.Context(), "internal error",
+		logging.Err(err), "method", r.Method, "path", r.URL.Path,
+	)
+	writeProblem(rw, r, http.StatusInternalServerError, CodeInternal, "Internal server error")
+}
+
+// notFound answers requests to unknown routes.
+func notFound(rw http.ResponseWriter, r *http.Request) {
+	writeProblem(rw, r, http.StatusNotFound, CodeNotFound, "Route not found")
+}
+
+// methodNotAllowed answers requests to known route with wrong method.
+func methodNotAllowed(rw http.ResponseWriter, r *http.Request) {
+	writeProblem(rw, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+}
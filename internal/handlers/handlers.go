@@ -1,39 +1,78 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/getkin/kin-openapi/routers"
 	"github.com/go-chi/chi/v5"
 	"github.com/hrapovd1/loyalty-account/internal/auth"
 	"github.com/hrapovd1/loyalty-account/internal/config"
-	"github.com/hrapovd1/loyalty-account/internal/dbstorage"
+	"github.com/hrapovd1/loyalty-account/internal/health"
+	"github.com/hrapovd1/loyalty-account/internal/metrics"
 	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/openapi"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/tracing"
 	"github.com/hrapovd1/loyalty-account/internal/types"
 	"github.com/hrapovd1/loyalty-account/internal/usecase"
 )
 
 type AppHandler struct {
 	AccrualAddress string
-	Storage        *dbstorage.DBStorage
-	Logger         *log.Logger
+	Storage        storage.Storage
+	Hasher         auth.PasswordHasher
+	Keys           *auth.Keyring
+	Logger         *slog.Logger
+	// WebhookSecret signs pushes of accrual system, without it webhook
+	// is disabled. Orders are polled if push is not received in PushTimeout.
+	WebhookSecret []byte
+	PushTimeout   time.Duration
+	// Readiness checks storage, other dependencies are added by caller.
+	Readiness *health.Checker
+	// IdempotencyTTL is time while response to request with
+	// Idempotency-Key is replayed.
+	IdempotencyTTL time.Duration
+	// API finds operation of OpenAPI document to validate request.
+	API routers.Router
 }
 
-// NewAppHandler return new app with db connect.
-func NewAppHandler(conf config.Config, logger *log.Logger) (*AppHandler, error) {
+// NewAppHandler return new app with given storage.
+func NewAppHandler(conf config.Config, store storage.Storage, logger *slog.Logger) (*AppHandler, error) {
 	app := &AppHandler{
 		AccrualAddress: conf.AccrualAddress,
+		Storage:        store,
 		Logger:         logger,
+		Readiness:      &health.Checker{},
+		IdempotencyTTL: conf.IdempotencyTTL,
 	}
-	storage, err := dbstorage.NewDB(conf.DatabaseDSN)
+	app.Readiness.Add("db", store.Ready)
+	if conf.AccrualWebhookSecret != "" {
+		app.WebhookSecret = []byte(conf.AccrualWebhookSecret)
+		app.PushTimeout = conf.AccrualPushTimeout
+	}
+	hasher, err := auth.NewPasswordHasher(conf.PasswordHasher)
 	if err != nil {
 		return app, err
 	}
-	app.Storage = &storage
+	app.Hasher = hasher
+	if conf.JWTKeysFile == "" && conf.JWTSecret == "" {
+		logger.Warn("JWT signing key is not configured, tokens will be invalidated after restart")
+	}
+	keys, err := auth.LoadKeyring(conf.JWTKeysFile, conf.JWTSecret)
+	if err != nil {
+		return app, err
+	}
+	app.Keys = keys
+	api, err := openapi.NewRouter()
+	if err != nil {
+		return app, err
+	}
+	app.API = api
 
 	return app, nil
 }
@@ -42,23 +81,63 @@ func NewAppHandler(conf config.Config, logger *log.Logger) (*AppHandler, error)
 func NewRouter(app *AppHandler) *chi.Mux {
 	// Публикация API
 	router := chi.NewRouter()
+	router.Use(RequestIDMiddle)
+	router.Use(tracing.Middleware)
+	router.Use(app.AccessLog)
+	router.Use(MetricsMiddle)
 	router.Use(GzipMiddle)
+	router.Use(app.ValidateRequest)
+	router.NotFound(notFound)
+	router.MethodNotAllowed(methodNotAllowed)
 
 	// Публично доступные маршруты.
 	router.Group(
 		func(r chi.Router) {
 			r.Post("/api/user/register", app.Register)
 			r.Post("/api/user/login", app.Login)
+			r.Post("/api/user/token/refresh", app.Refresh)
+			r.Get("/.well-known/jwks.json", app.JWKS)
+			r.Get("/healthz", app.Healthz)
+			r.Get("/readyz", app.Readyz)
+			r.Handle("/metrics", metrics.Handler())
+			r.Handle("/openapi.json", openapi.Handler())
 		})
 
+	// Webhook системы начисления аутентифицируется подписью запроса.
+	if app.WebhookSecret != nil {
+		router.Post("/api/accrual/webhook", app.AccrualWebhook)
+	}
+
 	// Маршруты для аутентифицированных пользователей.
 	router.Group(func(r chi.Router) {
-		r.Use(Authenticator)
+		r.Use(app.Authenticator)
 		r.Get("/api/user/orders", app.GetOrders)
-		r.Post("/api/user/orders", app.PostOrders)
+		r.With(app.Idempotent).Post("/api/user/orders", app.PostOrders)
 		r.Get("/api/user/balance", app.GetBalance)
-		r.Post("/api/user/balance/withdraw", app.Withdraw)
+		r.With(app.Idempotent).Post("/api/user/balance/withdraw", app.Withdraw)
 		r.Get("/api/user/withdrawals", app.Withdrawals)
+		r.Get("/api/user/adjustments", app.Adjustments)
+		r.Get("/api/user/ledger", app.Ledger)
+		r.Post("/api/user/logout", app.Logout)
+		r.Get("/api/user/sessions", app.GetSessions)
+		r.Delete("/api/user/sessions", app.DeleteSessions)
+		r.Delete("/api/user/sessions/{id}", app.DeleteSession)
+	})
+
+	// Маршруты для сотрудников поддержки и администраторов.
+	router.Route("/api/admin", func(r chi.Router) {
+		r.Use(app.Authenticator)
+		r.Use(RequireRole(auth.RoleSupport, auth.RoleAdmin))
+		r.Get("/users/{login}", app.AdminGetUser)
+		r.Get("/users/{login}/orders", app.AdminGetOrders)
+		r.Get("/users/{login}/withdrawals", app.AdminGetWithdrawals)
+		r.Get("/users/{login}/balance", app.AdminGetBalance)
+		r.Get("/users/{login}/adjustments", app.AdminGetAdjustments)
+		r.Post("/users/{login}/adjustments", app.AdminAdjustBalance)
+		r.Get("/users/{login}/ledger", app.AdminGetLedger)
+		r.Get("/orders/stuck", app.AdminGetStuckOrders)
+		r.With(RequireRole(auth.RoleAdmin)).Post("/ledger/{id}/reverse", app.AdminReverseTransaction)
+		r.With(RequireRole(auth.RoleAdmin)).Put("/users/{login}/role", app.AdminSetRole)
 	})
 
 	return router
@@ -69,50 +148,52 @@ func (app *AppHandler) Register(rw http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 
-	user := models.User{
-		Account: models.Account{Balance: sql.NullFloat64{
-			Float64: float64(0),
-			Valid:   true,
-		}},
-	}
+	var user models.User
 	if err := json.Unmarshal(body, &user); err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidRequest, "Wrong body format")
 		return
 	}
 
 	if user.Login == "" || user.Password == "" {
-		http.Error(rw, "wrong body format", http.StatusBadRequest)
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidRequest, "Wrong body format")
 		return
 	}
 
-	if err := auth.CreateUser(r.Context(), app.Storage, user); err != nil {
-		if errors.Is(err, dbstorage.ErrUserAlreadyExists) {
-			http.Error(rw, err.Error(), http.StatusConflict)
+	if err := auth.CreateUser(r.Context(), app.Storage, app.Hasher, user); err != nil {
+		if errors.Is(err, storage.ErrUserAlreadyExists) {
+			app.writeError(rw, r, http.StatusConflict, err)
 			return
 		}
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 
-	token, err := auth.GetToken(r.Context(), app.Storage, user)
+	tokens, err := auth.GetToken(r.Context(), app.Storage, app.Hasher, app.Keys, user, clientInfo(r))
 	if err != nil {
-		if errors.Is(err, dbstorage.ErrInvalidLoginPassword) {
-			http.Error(rw, err.Error(), http.StatusUnauthorized)
+		if errors.Is(err, storage.ErrInvalidLoginPassword) {
+			app.writeError(rw, r, http.StatusUnauthorized, err)
 			return
 		}
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 
-	rw.Header().Set("Authorization", token)
+	resp, err := json.Marshal(tokens)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	rw.Header().Set("Authorization", tokens.Authtoken)
+	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusOK)
-	_, err = rw.Write([]byte(""))
+	_, err = rw.Write(resp)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 }
@@ -122,72 +203,118 @@ func (app *AppHandler) Login(rw http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 
 	var user models.User
 	if err := json.Unmarshal(body, &user); err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidRequest, "Wrong body format")
 		return
 	}
 
 	if user.Login == "" || user.Password == "" {
-		http.Error(rw, "wrong body format", http.StatusBadRequest)
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidRequest, "Wrong body format")
 		return
 	}
 
-	token, err := auth.GetToken(r.Context(), app.Storage, user)
+	tokens, err := auth.GetToken(r.Context(), app.Storage, app.Hasher, app.Keys, user, clientInfo(r))
 	if err != nil {
-		if errors.Is(err, dbstorage.ErrInvalidLoginPassword) {
-			http.Error(rw, err.Error(), http.StatusUnauthorized)
+		if errors.Is(err, storage.ErrInvalidLoginPassword) {
+			app.writeError(rw, r, http.StatusUnauthorized, err)
 			return
 		}
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
+		return
+	}
+
+	resp, err := json.Marshal(tokens)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	rw.Header().Set("Authorization", tokens.Authtoken)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		app.internalError(rw, r, err)
 		return
 	}
+}
 
-	resp, err := json.Marshal(types.LoginResponse{Authtoken: token})
+// JWKS GET handler return public keys to verify auth tokens.
+func (app *AppHandler) JWKS(rw http.ResponseWriter, r *http.Request) {
+	resp, err := json.Marshal(app.Keys.JWKS())
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 
-	rw.Header().Set("Authorization", token)
+	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusOK)
 	_, err = rw.Write(resp)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 }
 
 // GetOrders handler return list of put orders.
 func (app *AppHandler) GetOrders(rw http.ResponseWriter, r *http.Request) {
-	login := r.Header.Get("Login")
+	app.writeOrders(rw, r, r.Header.Get("Login"))
+}
 
+// writeOrders writes list of user orders to response.
+func (app *AppHandler) writeOrders(rw http.ResponseWriter, r *http.Request, login string) {
 	rw.Header().Set("Content-Type", "application/json")
 
-	orders, err := app.Storage.GetOrders(r.Context(), login)
+	query, err := parseListQuery(r, []string{
+		models.OrderNew, models.OrderProcessing, models.OrderInvalid, models.OrderProcessed,
+	})
+	if err != nil {
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidQuery, err.Error())
+		return
+	}
+	// Зависшие заказы пользователь видит в статусе PROCESSING.
+	if contains(query.Statuses, models.OrderProcessing) {
+		query.Statuses = append(query.Statuses, models.OrderStuck)
+	}
+	limit := query.Limit
+	// лишняя запись показывает, что есть следующая страница
+	query.Limit++
+
+	orders, err := app.Storage.GetOrders(r.Context(), login, query)
 	if err != nil {
-		if errors.Is(err, dbstorage.ErrNoOrders) {
-			http.Error(rw, err.Error(), http.StatusNoContent)
+		if errors.Is(err, storage.ErrNoOrders) {
+			rw.WriteHeader(http.StatusNoContent)
 			return
 		}
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			app.writeError(rw, r, http.StatusNotFound, err)
+			return
+		}
+		app.internalError(rw, r, err)
 		return
 	}
 
+	if len(orders) > limit {
+		orders = orders[:limit]
+		last := orders[limit-1]
+		setNextPage(rw, r, types.Cursor{Time: last.UploadedAt, ID: last.ID})
+	}
+
 	resp, err := json.Marshal(usecase.OrdersTimeFormat(orders))
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 
 	rw.WriteHeader(http.StatusOK)
 	_, err = rw.Write(resp)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 
@@ -198,7 +325,7 @@ func (app *AppHandler) PostOrders(rw http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 	bodyStr := string(body)
@@ -207,48 +334,51 @@ func (app *AppHandler) PostOrders(rw http.ResponseWriter, r *http.Request) {
 
 	contentType := r.Header.Get("Content-type")
 	if contentType != "text/plain" || bodyStr == "" {
-		http.Error(rw, "Invalid request", http.StatusBadRequest)
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid request")
 		return
 	}
 
 	if !usecase.IsOrderNumValid(bodyStr) {
-		http.Error(rw, "Order number is not valid", http.StatusUnprocessableEntity)
+		writeProblem(rw, r, http.StatusUnprocessableEntity, CodeInvalidOrderNumber, "Order number is not valid")
 		return
 	}
 
-	if err := usecase.SaveOrder(r.Context(), app.Storage, login, bodyStr); err != nil {
-		if errors.Is(err, dbstorage.ErrOrderExists) {
-			http.Error(rw, "Order exists", http.StatusOK)
+	if err := usecase.SaveOrder(r.Context(), app.Storage, login, bodyStr, app.PushTimeout); err != nil {
+		if errors.Is(err, storage.ErrOrderExists) {
+			rw.WriteHeader(http.StatusOK)
 			return
 		}
-		if errors.Is(err, dbstorage.ErrOrderExistsAnother) {
-			http.Error(rw, "Order exists", http.StatusConflict)
+		if errors.Is(err, storage.ErrOrderExistsAnother) {
+			app.writeError(rw, r, http.StatusConflict, err)
 			return
 		}
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 	rw.WriteHeader(http.StatusAccepted)
 	_, err = rw.Write([]byte(""))
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 }
 
 // GetBalance handler return user accrual balance.
 func (app *AppHandler) GetBalance(rw http.ResponseWriter, r *http.Request) {
-	login := r.Header.Get("Login")
+	app.writeBalance(rw, r, r.Header.Get("Login"))
+}
 
+// writeBalance writes user balance to response.
+func (app *AppHandler) writeBalance(rw http.ResponseWriter, r *http.Request, login string) {
 	result, err := app.Storage.GetBalance(r.Context(), login)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 
 	resultJSON, err := json.Marshal(result)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 
@@ -256,7 +386,7 @@ func (app *AppHandler) GetBalance(rw http.ResponseWriter, r *http.Request) {
 	rw.WriteHeader(http.StatusOK)
 	_, err = rw.Write(resultJSON)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 }
@@ -268,34 +398,43 @@ func (app *AppHandler) Withdraw(rw http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	defer r.Body.Close()
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 
-	var orderLog models.OrderLog
-	if err := json.Unmarshal(body, &orderLog); err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	var req types.WithdrawRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidRequest, "Wrong body format")
 		return
 	}
 
-	if !usecase.IsOrderNumValid(orderLog.OrderNumber) {
-		http.Error(rw, "Order number is not valid", http.StatusUnprocessableEntity)
+	if !usecase.IsOrderNumValid(req.Order) {
+		writeProblem(rw, r, http.StatusUnprocessableEntity, CodeInvalidOrderNumber, "Order number is not valid")
 		return
 	}
+	orderLog := models.OrderLog{OrderNumber: req.Order, Sum: req.Sum}
 
 	if err = app.Storage.WithdrawOrder(r.Context(), login, orderLog); err != nil {
-		if errors.Is(err, dbstorage.ErrNotEnoughFunds) {
-			http.Error(rw, err.Error(), http.StatusPaymentRequired)
+		if errors.Is(err, storage.ErrNotEnoughFunds) {
+			metrics.Withdrawals.WithLabelValues(metrics.WithdrawalNotEnoughFunds).Inc()
+			app.writeError(rw, r, http.StatusPaymentRequired, err)
+			return
+		}
+		if errors.Is(err, storage.ErrWithdrawalExists) {
+			metrics.Withdrawals.WithLabelValues(metrics.WithdrawalDuplicate).Inc()
+			app.writeError(rw, r, http.StatusConflict, err)
 			return
 		}
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
+	metrics.Withdrawals.WithLabelValues(metrics.WithdrawalOK).Inc()
+	metrics.Withdrawn.Add(orderLog.Sum.Float64())
 
 	rw.WriteHeader(http.StatusOK)
 	_, err = rw.Write([]byte(""))
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 
@@ -303,21 +442,114 @@ func (app *AppHandler) Withdraw(rw http.ResponseWriter, r *http.Request) {
 
 // Withdrawals GET handler return list of payment with accrual.
 func (app *AppHandler) Withdrawals(rw http.ResponseWriter, r *http.Request) {
-	login := r.Header.Get("Login")
+	app.writeWithdrawals(rw, r, r.Header.Get("Login"))
+}
 
-	orderLogs, err := app.Storage.GetOrderLogs(r.Context(), login)
+// writeWithdrawals writes list of user withdrawals to response.
+func (app *AppHandler) writeWithdrawals(rw http.ResponseWriter, r *http.Request, login string) {
+	query, err := parseListQuery(r, nil)
 	if err != nil {
-		if errors.Is(err, dbstorage.ErrNoOrders) {
-			http.Error(rw, err.Error(), http.StatusNoContent)
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidQuery, err.Error())
+		return
+	}
+	limit := query.Limit
+	query.Limit++
+
+	orderLogs, err := app.Storage.GetOrderLogs(r.Context(), login, query)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoOrders) {
+			rw.WriteHeader(http.StatusNoContent)
 			return
 		}
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			app.writeError(rw, r, http.StatusNotFound, err)
+			return
+		}
+		app.internalError(rw, r, err)
 		return
 	}
 
+	if len(orderLogs) > limit {
+		orderLogs = orderLogs[:limit]
+		last := orderLogs[limit-1]
+		setNextPage(rw, r, types.Cursor{Time: last.ProcessedAt, ID: last.ID})
+	}
+
 	resp, err := json.Marshal(usecase.OrderLogsTimeFormat(orderLogs))
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
+
+// Adjustments GET handler return list of manual balance adjustments.
+func (app *AppHandler) Adjustments(rw http.ResponseWriter, r *http.Request) {
+	app.writeAdjustments(rw, r, r.Header.Get("Login"), false)
+}
+
+// writeAdjustments writes list of user adjustments to response.
+func (app *AppHandler) writeAdjustments(rw http.ResponseWriter, r *http.Request, login string, withAdmin bool) {
+	adjustments, err := app.Storage.GetAdjustments(r.Context(), login)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoAdjustments) {
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if errors.Is(err, storage.ErrUserNotFound) {
+			app.writeError(rw, r, http.StatusNotFound, err)
+			return
+		}
+		app.internalError(rw, r, err)
+		return
+	}
+
+	resp, err := json.Marshal(usecase.AdjustmentsTimeFormat(adjustments, withAdmin))
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
+
+// Ledger GET handler return postings of user account.
+func (app *AppHandler) Ledger(rw http.ResponseWriter, r *http.Request) {
+	app.writeLedger(rw, r, r.Header.Get("Login"))
+}
+
+// writeLedger writes list of user ledger entries to response.
+func (app *AppHandler) writeLedger(rw http.ResponseWriter, r *http.Request, login string) {
+	entries, err := app.Storage.GetLedger(r.Context(), login)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			app.writeError(rw, r, http.StatusNotFound, err)
+			return
+		}
+		app.internalError(rw, r, err)
+		return
+	}
+	if len(entries) == 0 {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	resp, err := json.Marshal(usecase.LedgerTimeFormat(entries))
+	if err != nil {
+		app.internalError(rw, r, err)
 		return
 	}
 
@@ -325,7 +557,7 @@ func (app *AppHandler) Withdrawals(rw http.ResponseWriter, r *http.Request) {
 	rw.WriteHeader(http.StatusOK)
 	_, err = rw.Write(resp)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		app.internalError(rw, r, err)
 		return
 	}
 }
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/hrapovd1/loyalty-account/internal/logging"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+)
+
+// Healthz handler answers while process is alive.
+func (app *AppHandler) Healthz(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain")
+	rw.WriteHeader(http.StatusOK)
+	_, err := rw.Write([]byte("ok"))
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
+
+// Readyz handler returns results of readiness checks, status is 503
+// when any of them failed. Errors of checks are only logged.
+func (app *AppHandler) Readyz(rw http.ResponseWriter, r *http.Request) {
+	results, ok := app.Readiness.Run(r.Context())
+	for _, result := range results {
+		if result.Err != nil {
+			app.Logger.WarnContext(r.Context(), "readiness check failed",
+				"check", result.Name, logging.Err(result.Err))
+		}
+	}
+	resp := types.ReadinessResponse{Status: "ok", Checks: results}
+	code := http.StatusOK
+	if !ok {
+		resp.Status = "fail"
+		code = http.StatusServiceUnavailable
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	_, err = rw.Write(body)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
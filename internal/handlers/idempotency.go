@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hrapovd1/loyalty-account/internal/logging"
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+)
+
+// maxIdempotencyKeyLength limits Idempotency-Key accepted from client.
+const maxIdempotencyKeyLength = 255
+
+// recordWriter passes response to client and keeps its copy.
+type recordWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Idempotent makes request with Idempotency-Key header safe to retry.
+// Response is saved with hash of request and replayed to retry with
+// the same key, reuse of key with another request is answered by 409.
+// Responses 5xx are not saved, so such request may be retried. It must
+// be used after Authenticator.
+func (app *AppHandler) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeProblem(rw, r, http.StatusBadRequest, CodeInvalidRequest, "Idempotency-Key is too long")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			app.internalError(rw, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := requestHash(r, body)
+		saved, err := app.Storage.CreateIdempotencyKey(
+			r.Context(),
+			r.Header.Get("Login"),
+			models.IdempotencyKey{Key: key, RequestHash: hash},
+			time.Now().Add(-app.IdempotencyTTL).Unix(),
+		)
+		if err != nil {
+			if errors.Is(err, storage.ErrIdempotencyKeyExists) {
+				replay(rw, r, saved, hash)
+				return
+			}
+			app.internalError(rw, r, err)
+			return
+		}
+
+		// Ответ сохраняется, даже если клиент уже отключился.
+		ctx := context.WithoutCancel(r.Context())
+		ww := &recordWriter{ResponseWriter: rw}
+		completed := false
+		defer func() {
+			// Ключ обработчика, который упал или ответил 5xx, удаляется,
+			// чтобы запрос можно было повторить.
+			if completed {
+				return
+			}
+			if err := app.Storage.DeleteIdempotencyKey(ctx, saved.ID); err != nil {
+				app.Logger.ErrorContext(ctx, "can't delete idempotency key", logging.Err(err))
+			}
+		}()
+		next.ServeHTTP(ww, r)
+
+		if ww.status == 0 {
+			ww.status = http.StatusOK
+		}
+		if ww.status >= http.StatusInternalServerError {
+			return
+		}
+		saved.Status = ww.status
+		saved.ContentType = rw.Header().Get("Content-Type")
+		saved.Body = ww.body.Bytes()
+		if err := app.Storage.CompleteIdempotencyKey(ctx, *saved); err != nil {
+			app.Logger.ErrorContext(ctx, "can't save idempotent response", logging.Err(err))
+			return
+		}
+		completed = true
+	})
+}
+
+// requestHash returns hash of request method, path and body.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method)
+	io.WriteString(h, " ")
+	io.WriteString(h, r.URL.Path)
+	io.WriteString(h, "\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replay answers retry of request with saved response.
+func replay(rw http.ResponseWriter, r *http.Request, saved *models.IdempotencyKey, hash string) {
+	if saved.RequestHash != hash {
+		writeProblem(rw, r, http.StatusConflict, CodeIdempotencyKeyReused, "Idempotency-Key is already used for another request")
+		return
+	}
+	if saved.Status == 0 {
+		writeProblem(rw, r, http.StatusConflict, CodeIdempotencyInFlight, "Request with this Idempotency-Key is in progress")
+		return
+	}
+	if saved.ContentType != "" {
+		rw.Header().Set("Content-Type", saved.ContentType)
+	}
+	rw.Header().Set("Idempotent-Replayed", "true")
+	rw.WriteHeader(saved.Status)
+	// клиент получает сохраненный ответ, ошибку записи передать уже некому
+	_, _ = rw.Write(saved.Body)
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hrapovd1/loyalty-account/internal/types"
+)
+
+// Size of page of list when limit is not set and maximum size.
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// parseListQuery reads page parameters of list request: limit, cursor,
+// sort (asc or desc), from and to in RFC3339 and comma separated status
+// if statuses are allowed.
+func parseListQuery(r *http.Request, statuses []string) (types.ListQuery, error) {
+	params := r.URL.Query()
+	query := types.ListQuery{Limit: defaultPageSize}
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return query, fmt.Errorf("limit must be from 1 to %d", maxPageSize)
+		}
+		query.Limit = limit
+	}
+
+	switch params.Get("sort") {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, errors.New("sort must be asc or desc")
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *int64
+	}{
+		{"from", &query.From},
+		{"to", &query.To},
+	} {
+		value := params.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, fmt.Errorf("%s must be time in RFC3339 format", param.name)
+		}
+		*param.value = t.Unix()
+	}
+
+	if value := params.Get("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return query, err
+		}
+		query.After = &cursor
+	}
+
+	if value := params.Get("status"); value != "" {
+		for _, status := range strings.Split(value, ",") {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			if !contains(statuses, status) {
+				return query, fmt.Errorf("unknown status %q", status)
+			}
+			query.Statuses = append(query.Statuses, status)
+		}
+	}
+	return query, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeCursor returns opaque value of cursor for client.
+func encodeCursor(cursor types.Cursor) string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(fmt.Sprintf("%d.%d", cursor.Time, cursor.ID)),
+	)
+}
+
+func decodeCursor(value string) (types.Cursor, error) {
+	var cursor types.Cursor
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, errInvalidCursor
+	}
+	t, id, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return cursor, errInvalidCursor
+	}
+	if cursor.Time, err = strconv.ParseInt(t, 10, 64); err != nil {
+		return cursor, errInvalidCursor
+	}
+	parsedID, err := strconv.ParseUint(id, 10, 0)
+	if err != nil {
+		return cursor, errInvalidCursor
+	}
+	cursor.ID = uint(parsedID)
+	return cursor, nil
+}
+
+// setNextPage adds to response cursor of next page in X-Next-Cursor
+// header and link to next page in Link header.
+func setNextPage(rw http.ResponseWriter, r *http.Request, cursor types.Cursor) {
+	value := encodeCursor(cursor)
+	next := *r.URL
+	params := next.Query()
+	params.Set("cursor", value)
+	next.RawQuery = params.Encode()
+
+	rw.Header().Set("X-Next-Cursor", value)
+	rw.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+}
@@ -2,11 +2,22 @@ package handlers
 
 import (
 	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/hrapovd1/loyalty-account/internal/auth"
+	"github.com/hrapovd1/loyalty-account/internal/logging"
+	"github.com/hrapovd1/loyalty-account/internal/metrics"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
 )
 
 type gzipWriter struct {
@@ -43,21 +54,140 @@ func GzipMiddle(next http.Handler) http.Handler {
 	})
 }
 
-func Authenticator(next http.Handler) http.Handler {
+// maxRequestIDLength limits request ID accepted from client.
+const maxRequestIDLength = 64
+
+// RequestIDMiddle takes request ID from X-Request-ID header or generates
+// new one, puts it to request context and response header.
+func RequestIDMiddle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID allows only short IDs of safe symbols, so client can't
+// inject anything to logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	// при ошибке источника случайных чисел ID будет нулевым, запрос это не ломает
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AccessLog logs every request with status and duration, it must be used
+// after RequestIDMiddle.
+func (app *AppHandler) AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		app.Logger.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// MetricsMiddle observes duration of request by route pattern of chi,
+// requests without route are observed as "unmatched".
+func MetricsMiddle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		// шаблон маршрута известен только после обработки запроса роутером
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.HTTPRequestDuration.WithLabelValues(
+			r.Method, route, strconv.Itoa(status),
+		).Observe(time.Since(start).Seconds())
+	})
+}
+
+func (app *AppHandler) Authenticator(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		// Get access token from request
 		authParam := r.Header.Get("Authorization")
 
 		// Check token to valid
-		login, err := auth.CheckToken(authParam)
+		claims, err := auth.CheckToken(app.Keys, authParam)
 		if err != nil {
-			http.Error(rw, err.Error(), http.StatusUnauthorized)
+			// подробности ошибки разбора токена клиенту не нужны
+			writeProblem(rw, r, http.StatusUnauthorized, CodeInvalidToken, "Token is invalid or expired")
 			return
 		}
 
-		r.Header["Login"] = []string{login}
+		// Check session of token is not revoked
+		if _, err := app.Storage.GetSession(r.Context(), claims.SessionID); err != nil {
+			if errors.Is(err, storage.ErrSessionNotFound) {
+				app.writeError(rw, r, http.StatusUnauthorized, err)
+				return
+			}
+			app.internalError(rw, r, err)
+			return
+		}
+
+		r.Header["Login"] = []string{claims.Login}
+		r.Header["Session"] = []string{strconv.FormatUint(uint64(claims.SessionID), 10)}
+		// Токены, выданные до появления ролей, не содержат роль.
+		if claims.Role == "" {
+			claims.Role = auth.RoleUser
+		}
+		r.Header["Role"] = []string{claims.Role}
 
 		// Token is authenticated, pass it through
 		next.ServeHTTP(rw, r)
 	})
 }
+
+// RequireRole allows request only for users with one of roles,
+// it must be used after Authenticator.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			role := r.Header.Get("Role")
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(rw, r)
+					return
+				}
+			}
+			writeProblem(rw, r, http.StatusForbidden, CodeAccessDenied, "Access denied")
+		})
+	}
+}
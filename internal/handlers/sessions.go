@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hrapovd1/loyalty-account/internal/auth"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+	"github.com/hrapovd1/loyalty-account/internal/usecase"
+)
+
+// Refresh POST handler exchanges refresh token to new token pair.
+func (app *AppHandler) Refresh(rw http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	var req types.RefreshRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.RefreshToken == "" {
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidRequest, "Wrong body format")
+		return
+	}
+
+	tokens, err := auth.RefreshToken(r.Context(), app.Storage, app.Keys, req.RefreshToken, clientInfo(r))
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidRefreshToken) {
+			app.writeError(rw, r, http.StatusUnauthorized, err)
+			return
+		}
+		app.internalError(rw, r, err)
+		return
+	}
+
+	resp, err := json.Marshal(tokens)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	rw.Header().Set("Authorization", tokens.Authtoken)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
+
+// Logout POST handler revokes session of current token.
+func (app *AppHandler) Logout(rw http.ResponseWriter, r *http.Request) {
+	login := r.Header.Get("Login")
+
+	if err := app.Storage.RevokeSession(r.Context(), login, currentSession(r)); err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	_, err := rw.Write([]byte(""))
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
+
+// GetSessions handler return list of active user sessions.
+func (app *AppHandler) GetSessions(rw http.ResponseWriter, r *http.Request) {
+	login := r.Header.Get("Login")
+
+	sessions, err := app.Storage.GetSessions(r.Context(), login)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	resp, err := json.Marshal(usecase.SessionsTimeFormat(sessions, currentSession(r)))
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(resp)
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
+
+// DeleteSession handler revokes one of user sessions.
+func (app *AppHandler) DeleteSession(rw http.ResponseWriter, r *http.Request) {
+	login := r.Header.Get("Login")
+
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 0)
+	if err != nil {
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid session id")
+		return
+	}
+
+	if err := app.Storage.RevokeSession(r.Context(), login, uint(id)); err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			app.writeError(rw, r, http.StatusNotFound, err)
+			return
+		}
+		app.internalError(rw, r, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write([]byte(""))
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
+
+// DeleteSessions handler revokes all user sessions except current one.
+func (app *AppHandler) DeleteSessions(rw http.ResponseWriter, r *http.Request) {
+	login := r.Header.Get("Login")
+
+	if err := app.Storage.RevokeSessions(r.Context(), login, currentSession(r)); err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	_, err := rw.Write([]byte(""))
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
+
+// currentSession return session id set by Authenticator.
+func currentSession(r *http.Request) uint {
+	id, _ := strconv.ParseUint(r.Header.Get("Session"), 10, 0)
+	return uint(id)
+}
+
+func clientInfo(r *http.Request) types.ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return types.ClientInfo{
+		UserAgent: r.UserAgent(),
+		IPAddress: ip,
+	}
+}
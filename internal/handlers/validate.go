@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+)
+
+// prefixInvalidContentType starts reason of openapi3filter error about
+// content type not declared for request body.
+const prefixInvalidContentType = "header Content-Type has unexpected value"
+
+// ValidateRequest checks parameters, content type and body of request
+// against operation of OpenAPI document. Requests to routes missing in
+// document are passed through, router answers them 404 or 405.
+// Authentication is checked by Authenticator, not here.
+func (app *AppHandler) ValidateRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := app.API.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		// Тело запроса читается валидатором и возвращается в r.Body.
+		err = openapi3filter.ValidateRequest(r.Context(), &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+				SkipSettingDefaults: true,
+			},
+		})
+		if err != nil {
+			app.validationError(rw, r, err)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// validationError answers client with problem of request, which
+// doesn't match OpenAPI document.
+func (app *AppHandler) validationError(rw http.ResponseWriter, r *http.Request, err error) {
+	var reqErr *openapi3filter.RequestError
+	if !errors.As(err, &reqErr) {
+		app.internalError(rw, r, err)
+		return
+	}
+	switch {
+	case strings.HasPrefix(reqErr.Reason, prefixInvalidContentType):
+		writeProblem(rw, r, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType,
+			fmt.Sprintf("Content type %q is not supported", r.Header.Get("Content-Type")))
+	case reqErr.Parameter != nil && reqErr.Parameter.In == openapi3.ParameterInQuery:
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidQuery, validationDetail(reqErr))
+	default:
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidRequest, validationDetail(reqErr))
+	}
+}
+
+// validationDetail returns short description of validation error
+// without schema, which openapi3filter adds to error message.
+func validationDetail(reqErr *openapi3filter.RequestError) string {
+	reason := reqErr.Reason
+	var schemaErr *openapi3.SchemaError
+	switch {
+	case errors.As(reqErr.Err, &schemaErr):
+		reason = schemaErr.Reason
+		if pointer := schemaErr.JSONPointer(); len(pointer) > 0 {
+			reason = fmt.Sprintf("%s: %s", strings.Join(pointer, "."), reason)
+		}
+	case errors.Is(reqErr.Err, openapi3filter.ErrInvalidRequired):
+		reason = "value is required"
+	case reason == "" && reqErr.Err != nil:
+		reason = reqErr.Err.Error()
+	}
+
+	switch {
+	case reqErr.Parameter != nil:
+		return fmt.Sprintf("Parameter %q in %s is invalid: %s", reqErr.Parameter.Name, reqErr.Parameter.In, reason)
+	case reqErr.RequestBody != nil:
+		return fmt.Sprintf("Request body is invalid: %s", reason)
+	}
+	return fmt.Sprintf("Request is invalid: %s", reason)
+}
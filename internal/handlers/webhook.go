@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hrapovd1/loyalty-account/internal/auth"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+	"github.com/hrapovd1/loyalty-account/internal/usecase"
+)
+
+// maxWebhookBody limits size of accrual system push.
+const maxWebhookBody = 1 << 20
+
+// AccrualWebhook POST handler accepts order status pushed by accrual
+// system. Request is signed by X-Accrual-Signature of X-Accrual-Timestamp
+// and body, repeated push of final status is accepted.
+func (app *AppHandler) AccrualWebhook(rw http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+
+	if err := auth.VerifyWebhook(
+		app.WebhookSecret,
+		r.Header.Get("X-Accrual-Timestamp"),
+		r.Header.Get("X-Accrual-Signature"),
+		body,
+		time.Now(),
+	); err != nil {
+		app.writeError(rw, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	var answer types.AccrualAnswer
+	if err := json.Unmarshal(body, &answer); err != nil || answer.OrderNumber == "" {
+		writeProblem(rw, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid request")
+		return
+	}
+
+	if err := usecase.ApplyAccrual(r.Context(), app.Storage, answer, app.PushTimeout); err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrUnknownAccrualStatus):
+			app.writeError(rw, r, http.StatusUnprocessableEntity, err)
+			return
+		case errors.Is(err, storage.ErrNoOrders):
+			app.writeError(rw, r, http.StatusNotFound, err)
+			return
+		case !errors.Is(err, storage.ErrOrderFinalized):
+			app.internalError(rw, r, err)
+			return
+		}
+		// Повтор уже примененного статуса не ошибка для отправителя.
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write([]byte(""))
+	if err != nil {
+		app.internalError(rw, r, err)
+		return
+	}
+}
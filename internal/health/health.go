@@ -0,0 +1,97 @@
+// Package health checks readiness of application dependencies for
+// probes of orchestrator.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hrapovd1/loyalty-account/internal/types"
+)
+
+// CheckTimeout limits every check of Checker.
+const CheckTimeout = 2 * time.Second
+
+// Check returns error when dependency is not ready.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs named checks in order they were added.
+type Checker struct {
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+// Add adds check with name to checker.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Run runs all checks and returns their results, ok is false when
+// any check failed.
+func (c *Checker) Run(ctx context.Context) ([]types.CheckResult, bool) {
+	c.mu.RLock()
+	checks := c.checks
+	c.mu.RUnlock()
+
+	ok := true
+	results := make([]types.CheckResult, 0, len(checks))
+	for _, nc := range checks {
+		checkCTX, cancel := context.WithTimeout(ctx, CheckTimeout)
+		err := nc.check(checkCTX)
+		cancel()
+		result := types.CheckResult{Name: nc.name, Status: "ok"}
+		if err != nil {
+			result.Status = "fail"
+			result.Err = err
+			ok = false
+		}
+		results = append(results, result)
+	}
+	return results, ok
+}
+
+// Cached returns check which calls check not more often than once in
+// ttl and returns its last result between calls.
+func Cached(check Check, ttl time.Duration) Check {
+	var mu sync.Mutex
+	var checkedAt time.Time
+	var lastErr error
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !checkedAt.IsZero() && time.Since(checkedAt) < ttl {
+			return lastErr
+		}
+		lastErr = check(ctx)
+		checkedAt = time.Now()
+		return lastErr
+	}
+}
+
+// Heartbeat returns check which fails when last beat is older than window
+// or there was no beat yet.
+func Heartbeat(last func() time.Time, window time.Duration) Check {
+	return func(ctx context.Context) error {
+		beat := last()
+		if beat.IsZero() {
+			return errors.New("no heartbeat yet")
+		}
+		since := time.Since(beat)
+		if since > window {
+			return fmt.Errorf("no heartbeat for %v, window is %v", since.Round(time.Second), window)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,63 @@
+package memstorage
+
+import (
+	"context"
+	"time"
+
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+)
+
+func (ms *MemStorage) CreateIdempotencyKey(ctx context.Context, login string, key models.IdempotencyKey, expiredBefore int64) (*models.IdempotencyKey, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	user, err := ms.user(login)
+	if err != nil {
+		return nil, err
+	}
+	for id, saved := range ms.idempotency {
+		if saved.UserID != user.ID {
+			continue
+		}
+		if saved.CreatedAt < expiredBefore {
+			delete(ms.idempotency, id)
+			continue
+		}
+		if saved.Key == key.Key {
+			saved.Body = append([]byte(nil), saved.Body...)
+			return &saved, storage.ErrIdempotencyKeyExists
+		}
+	}
+	key.ID = ms.nextID()
+	key.UserID = user.ID
+	key.Status = 0
+	key.ContentType = ""
+	key.Body = nil
+	key.CreatedAt = time.Now().Unix()
+	ms.idempotency[key.ID] = key
+	return &key, nil
+}
+
+func (ms *MemStorage) CompleteIdempotencyKey(ctx context.Context, key models.IdempotencyKey) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	saved, ok := ms.idempotency[key.ID]
+	if !ok {
+		return nil
+	}
+	saved.Status = key.Status
+	saved.ContentType = key.ContentType
+	saved.Body = append([]byte(nil), key.Body...)
+	ms.idempotency[key.ID] = saved
+	return nil
+}
+
+func (ms *MemStorage) DeleteIdempotencyKey(ctx context.Context, id uint) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.idempotency, id)
+	return nil
+}
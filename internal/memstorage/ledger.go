@@ -0,0 +1,197 @@
+package memstorage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/money"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+)
+
+// post appends balanced ledger transaction and applies its entries to
+// cached balances of user accounts, must be called under lock.
+func (ms *MemStorage) post(ltx models.LedgerTransaction) (models.LedgerTransaction, error) {
+	if len(ltx.Entries) < 2 {
+		return ltx, storage.ErrUnbalanced
+	}
+	var total money.Amount
+	deltas := make(map[uint]money.Amount, len(ltx.Entries))
+	for _, entry := range ltx.Entries {
+		if entry.Amount == 0 {
+			return ltx, fmt.Errorf("entry amount must not be 0")
+		}
+		if _, ok := ms.accounts[entry.AccountID]; !ok {
+			return ltx, fmt.Errorf("account %d not found", entry.AccountID)
+		}
+		total += entry.Amount
+		deltas[entry.AccountID] += entry.Amount
+	}
+	if total != 0 {
+		return ltx, storage.ErrUnbalanced
+	}
+	if ltx.Kind == models.LedgerAccrual {
+		for _, dbLtx := range ms.ledger {
+			if dbLtx.Kind == models.LedgerAccrual && ltx.OrderID != nil && dbLtx.OrderID != nil && *dbLtx.OrderID == *ltx.OrderID {
+				return ltx, storage.ErrOrderFinalized
+			}
+		}
+	}
+	for id, delta := range deltas {
+		account := ms.accounts[id]
+		if account.Code == nil && account.Balance+delta < 0 {
+			return ltx, storage.ErrNotEnoughFunds
+		}
+	}
+	for id, delta := range deltas {
+		if account := ms.accounts[id]; account.Code == nil {
+			account.Balance += delta
+			ms.accounts[id] = account
+		}
+	}
+
+	ltx.ID = ms.nextID()
+	ltx.CreatedAt = time.Now().Unix()
+	entries := make([]models.LedgerEntry, 0, len(ltx.Entries))
+	for _, entry := range ltx.Entries {
+		entry.ID = ms.nextID()
+		entry.TransactionID = ltx.ID
+		entries = append(entries, entry)
+	}
+	ltx.Entries = entries
+	ms.ledger = append(ms.ledger, ltx)
+	return ltx, nil
+}
+
+// transfer posts transaction ltx, which moves amount from system
+// account with code to account of user, must be called under lock.
+func (ms *MemStorage) transfer(ltx models.LedgerTransaction, userID uint, code string, amount money.Amount) error {
+	ltx.Entries = []models.LedgerEntry{
+		{AccountID: ms.userAccounts[userID], Amount: amount},
+		{AccountID: ms.systemAccounts[code], Amount: -amount},
+	}
+	_, err := ms.post(ltx)
+	return err
+}
+
+func (ms *MemStorage) GetLedger(ctx context.Context, login string) ([]models.LedgerEntry, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	entries := make([]models.LedgerEntry, 0)
+	user, err := ms.user(login)
+	if err != nil {
+		return entries, err
+	}
+	accountID := ms.userAccounts[user.ID]
+	for _, ltx := range ms.ledger {
+		for _, entry := range ltx.Entries {
+			if entry.AccountID != accountID {
+				continue
+			}
+			transaction := ltx
+			transaction.Entries = nil
+			entry.Transaction = &transaction
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (ms *MemStorage) ReverseTransaction(ctx context.Context, id uint) (*models.LedgerTransaction, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var original *models.LedgerTransaction
+	for i := range ms.ledger {
+		if ms.ledger[i].ID == id {
+			original = &ms.ledger[i]
+		}
+		if ms.ledger[i].ReversalOf != nil && *ms.ledger[i].ReversalOf == id {
+			return &models.LedgerTransaction{}, storage.ErrAlreadyReversed
+		}
+	}
+	if original == nil {
+		return &models.LedgerTransaction{}, storage.ErrTransactionNotFound
+	}
+	if original.Kind == models.LedgerReversal {
+		return &models.LedgerTransaction{}, storage.ErrNotReversible
+	}
+	originalID := original.ID
+	reversal := models.LedgerTransaction{
+		Kind:       models.LedgerReversal,
+		Reference:  original.Reference,
+		ReversalOf: &originalID,
+	}
+	for _, entry := range original.Entries {
+		reversal.Entries = append(reversal.Entries, models.LedgerEntry{
+			AccountID: entry.AccountID,
+			Amount:    -entry.Amount,
+		})
+	}
+	kind := original.Kind
+	reversal, err := ms.post(reversal)
+	if err != nil {
+		return &reversal, err
+	}
+	if kind == models.LedgerWithdrawal {
+		ms.reverseWithdrawal(reversal)
+	}
+	return &reversal, nil
+}
+
+// reverseWithdrawal marks withdrawal cancelled by reversal as reversed.
+func (ms *MemStorage) reverseWithdrawal(reversal models.LedgerTransaction) {
+	var userID uint
+	for _, entry := range reversal.Entries {
+		if account := ms.accounts[entry.AccountID]; account.Code == nil {
+			userID = account.UserID
+		}
+	}
+	for i := range ms.orderLogs {
+		orderLog := &ms.orderLogs[i]
+		if orderLog.UserID == userID && orderLog.OrderNumber == reversal.Reference && !orderLog.Reversed {
+			orderLog.Reversed = true
+			return
+		}
+	}
+}
+
+func (ms *MemStorage) CheckLedger(ctx context.Context) ([]types.LedgerDiscrepancy, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	discrepancies := make([]types.LedgerDiscrepancy, 0)
+	totals := make(map[uint]money.Amount)
+	unbalanced := make([]types.LedgerDiscrepancy, 0)
+	for _, ltx := range ms.ledger {
+		var sum money.Amount
+		for _, entry := range ltx.Entries {
+			totals[entry.AccountID] += entry.Amount
+			sum += entry.Amount
+		}
+		if sum != 0 {
+			unbalanced = append(unbalanced, types.LedgerDiscrepancy{TransactionID: ltx.ID, Actual: sum})
+		}
+	}
+	for id, account := range ms.accounts {
+		if account.Code != nil || account.Balance == totals[id] {
+			continue
+		}
+		var login string
+		if user, ok := ms.userByID(account.UserID); ok {
+			login = user.Login
+		}
+		discrepancies = append(discrepancies, types.LedgerDiscrepancy{
+			AccountID: id,
+			Account:   login,
+			Expected:  totals[id],
+			Actual:    account.Balance,
+		})
+	}
+	sort.Slice(discrepancies, func(i, j int) bool { return discrepancies[i].AccountID < discrepancies[j].AccountID })
+	return append(discrepancies, unbalanced...), nil
+}
@@ -0,0 +1,60 @@
+package memstorage
+
+import (
+	"sort"
+
+	"github.com/hrapovd1/loyalty-account/internal/types"
+)
+
+// listPage returns page of items selected by query, position returns
+// time and id of item.
+func listPage[T any](items []T, position func(T) (int64, uint), query types.ListQuery) []T {
+	less := func(a, b types.Cursor) bool {
+		if a.Time != b.Time {
+			return a.Time < b.Time
+		}
+		return a.ID < b.ID
+	}
+	cursor := func(item T) types.Cursor {
+		t, id := position(item)
+		return types.Cursor{Time: t, ID: id}
+	}
+
+	page := make([]T, 0, len(items))
+	for _, item := range items {
+		c := cursor(item)
+		if query.From > 0 && c.Time < query.From || query.To > 0 && c.Time >= query.To {
+			continue
+		}
+		if query.After != nil {
+			if !query.Desc && !less(*query.After, c) || query.Desc && !less(c, *query.After) {
+				continue
+			}
+		}
+		page = append(page, item)
+	}
+	sort.Slice(page, func(i, j int) bool {
+		if query.Desc {
+			return less(cursor(page[j]), cursor(page[i]))
+		}
+		return less(cursor(page[i]), cursor(page[j]))
+	})
+	if query.Limit > 0 && len(page) > query.Limit {
+		page = page[:query.Limit]
+	}
+	return page
+}
+
+// hasStatus reports whether status is in statuses, empty statuses
+// match any status.
+func hasStatus(statuses []string, status string) bool {
+	if len(statuses) == 0 {
+		return true
+	}
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
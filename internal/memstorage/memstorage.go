@@ -0,0 +1,600 @@
+package memstorage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+)
+
+// MemStorage keeps application state in memory, it is safe for
+// concurrent use and is intended for tests and local runs.
+type MemStorage struct {
+	mu             sync.RWMutex
+	users          map[string]models.User
+	accounts       map[uint]models.Account
+	userAccounts   map[uint]uint
+	systemAccounts map[string]uint
+	ledger         []models.LedgerTransaction
+	orders         []models.Order
+	orderLogs      []models.OrderLog
+	adjustments    []models.Adjustment
+	sessions       map[uint]models.Session
+	usedRefresh    map[string]uint
+	idempotency    map[uint]models.IdempotencyKey
+	lastID         uint
+}
+
+var _ storage.Storage = (*MemStorage)(nil)
+
+func NewMemStorage() *MemStorage {
+	ms := &MemStorage{
+		users:          make(map[string]models.User),
+		accounts:       make(map[uint]models.Account),
+		userAccounts:   make(map[uint]uint),
+		systemAccounts: make(map[string]uint),
+		sessions:       make(map[uint]models.Session),
+		usedRefresh:    make(map[string]uint),
+		idempotency:    make(map[uint]models.IdempotencyKey),
+	}
+	for _, code := range []string{models.SystemAccrual, models.SystemWithdrawal, models.SystemAdjustment} {
+		code := code
+		account := models.Account{ID: ms.nextID(), Code: &code}
+		ms.accounts[account.ID] = account
+		ms.systemAccounts[code] = account.ID
+	}
+	return ms
+}
+
+func (ms *MemStorage) Close() error {
+	return nil
+}
+
+func (ms *MemStorage) Ready(ctx context.Context) error {
+	return nil
+}
+
+// nextID returns unique identifier for new record, must be called under lock.
+func (ms *MemStorage) nextID() uint {
+	ms.lastID++
+	return ms.lastID
+}
+
+// user returns user by login, must be called under lock.
+func (ms *MemStorage) user(login string) (models.User, error) {
+	user, ok := ms.users[login]
+	if !ok {
+		return user, storage.ErrUserNotFound
+	}
+	return user, nil
+}
+
+// userByID returns user by identifier, must be called under lock.
+func (ms *MemStorage) userByID(id uint) (models.User, bool) {
+	for _, user := range ms.users {
+		if user.ID == id {
+			return user, true
+		}
+	}
+	return models.User{}, false
+}
+
+func (ms *MemStorage) CreateUser(ctx context.Context, user models.User) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.users[user.Login]; ok {
+		return storage.ErrUserAlreadyExists
+	}
+	user.ID = ms.nextID()
+	if user.Role == "" {
+		user.Role = "user"
+	}
+	account := models.Account{ID: ms.nextID(), UserID: user.ID}
+	ms.accounts[account.ID] = account
+	ms.userAccounts[user.ID] = account.ID
+	user.Account = models.Account{}
+	user.Orders, user.OrderLogs, user.Sessions, user.Adjustments = nil, nil, nil, nil
+	ms.users[user.Login] = user
+	return nil
+}
+
+func (ms *MemStorage) GetUser(ctx context.Context, login string) (*models.User, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	user, err := ms.user(login)
+	return &user, err
+}
+
+func (ms *MemStorage) UpdateUserPassword(ctx context.Context, login string, password string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	user, ok := ms.users[login]
+	if !ok {
+		return nil
+	}
+	user.Password = password
+	ms.users[login] = user
+	return nil
+}
+
+func (ms *MemStorage) SetUserRole(ctx context.Context, login string, role string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	user, err := ms.user(login)
+	if err != nil {
+		return err
+	}
+	user.Role = role
+	ms.users[login] = user
+	return nil
+}
+
+func (ms *MemStorage) CreateOrder(ctx context.Context, login string, order models.Order) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	user, err := ms.user(login)
+	if err != nil {
+		return err
+	}
+	for _, dbOrder := range ms.orders {
+		if dbOrder.Number == order.Number {
+			if dbOrder.UserID == user.ID {
+				return storage.ErrOrderExists
+			}
+			return storage.ErrOrderExistsAnother
+		}
+	}
+	order.ID = ms.nextID()
+	order.UserID = user.ID
+	order.UploadedAt = time.Now().Unix()
+	ms.orders = append(ms.orders, order)
+	return nil
+}
+
+func (ms *MemStorage) GetOrders(ctx context.Context, login string, query types.ListQuery) ([]models.Order, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	orders := make([]models.Order, 0)
+	user, err := ms.user(login)
+	if err != nil {
+		return orders, err
+	}
+	for _, order := range ms.orders {
+		if order.UserID == user.ID && hasStatus(query.Statuses, order.Status) {
+			orders = append(orders, order)
+		}
+	}
+	orders = listPage(orders, func(order models.Order) (int64, uint) {
+		return order.UploadedAt, order.ID
+	}, query)
+	if len(orders) == 0 {
+		return orders, storage.ErrNoOrders
+	}
+	return orders, nil
+}
+
+func (ms *MemStorage) GetBalance(ctx context.Context, login string) (*types.Balance, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var result types.Balance
+	user, ok := ms.users[login]
+	if !ok {
+		return &result, nil
+	}
+	result.Balance = ms.accounts[ms.userAccounts[user.ID]].Balance
+	for _, orderLog := range ms.orderLogs {
+		if orderLog.UserID == user.ID && !orderLog.Reversed {
+			result.Summ += orderLog.Sum
+		}
+	}
+	return &result, nil
+}
+
+func (ms *MemStorage) GetOrderLogs(ctx context.Context, login string, query types.ListQuery) ([]models.OrderLog, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	orderLogs := make([]models.OrderLog, 0)
+	user, err := ms.user(login)
+	if err != nil {
+		return orderLogs, err
+	}
+	for _, orderLog := range ms.orderLogs {
+		if orderLog.UserID == user.ID {
+			orderLogs = append(orderLogs, orderLog)
+		}
+	}
+	orderLogs = listPage(orderLogs, func(orderLog models.OrderLog) (int64, uint) {
+		return orderLog.ProcessedAt, orderLog.ID
+	}, query)
+	if len(orderLogs) == 0 {
+		return orderLogs, storage.ErrNoOrders
+	}
+	return orderLogs, nil
+}
+
+func (ms *MemStorage) WithdrawOrder(ctx context.Context, login string, orderLog models.OrderLog) error {
+	if orderLog.Sum <= 0 {
+		return fmt.Errorf("sum must be >0")
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	user, err := ms.user(login)
+	if err != nil {
+		return err
+	}
+	for _, paid := range ms.orderLogs {
+		if paid.UserID == user.ID && paid.OrderNumber == orderLog.OrderNumber && !paid.Reversed {
+			return storage.ErrWithdrawalExists
+		}
+	}
+	if err := ms.transfer(
+		models.LedgerTransaction{Kind: models.LedgerWithdrawal, Reference: orderLog.OrderNumber},
+		user.ID, models.SystemWithdrawal, -orderLog.Sum,
+	); err != nil {
+		return err
+	}
+	orderLog.ID = ms.nextID()
+	orderLog.UserID = user.ID
+	orderLog.ProcessedAt = time.Now().Unix()
+	ms.orderLogs = append(ms.orderLogs, orderLog)
+	return nil
+}
+
+func (ms *MemStorage) AdjustBalance(ctx context.Context, login string, adminLogin string, adjustment models.Adjustment) (*models.Adjustment, error) {
+	if adjustment.Sum == 0 {
+		return nil, fmt.Errorf("sum must not be 0")
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	user, err := ms.user(login)
+	if err != nil {
+		return &adjustment, err
+	}
+	admin, err := ms.user(adminLogin)
+	if err != nil {
+		return &adjustment, err
+	}
+	adjustment.ID = ms.nextID()
+	if err := ms.transfer(
+		models.LedgerTransaction{
+			Kind:      models.LedgerAdjustment,
+			Reference: strconv.FormatUint(uint64(adjustment.ID), 10),
+		},
+		user.ID, models.SystemAdjustment, adjustment.Sum,
+	); err != nil {
+		return &adjustment, err
+	}
+	adjustment.UserID = user.ID
+	adjustment.AdminID = admin.ID
+	adjustment.AdminLogin = admin.Login
+	adjustment.CreatedAt = time.Now().Unix()
+	ms.adjustments = append(ms.adjustments, adjustment)
+	return &adjustment, nil
+}
+
+func (ms *MemStorage) GetAdjustments(ctx context.Context, login string) ([]models.Adjustment, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	adjustments := make([]models.Adjustment, 0)
+	user, err := ms.user(login)
+	if err != nil {
+		return adjustments, err
+	}
+	for _, adjustment := range ms.adjustments {
+		if adjustment.UserID == user.ID {
+			adjustments = append(adjustments, adjustment)
+		}
+	}
+	if len(adjustments) == 0 {
+		return adjustments, storage.ErrNoAdjustments
+	}
+	return adjustments, nil
+}
+
+func (ms *MemStorage) DispatchGetOrders(ctx context.Context, status string) ([]string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	numList := make([]string, 0)
+	for _, order := range ms.orders {
+		if order.Status == status {
+			numList = append(numList, order.Number)
+		}
+	}
+	return numList, nil
+}
+
+func (ms *MemStorage) DispatchClaimOrders(ctx context.Context, instanceID string, lease time.Duration, limit int) ([]models.Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	due := make([]int, 0)
+	for i, order := range ms.orders {
+		if order.Status != models.OrderNew && order.Status != models.OrderProcessing {
+			continue
+		}
+		if order.NextCheckAt > now.Unix() {
+			continue
+		}
+		if order.ClaimedUntil >= now.Unix() && order.ClaimedBy != instanceID {
+			continue
+		}
+		due = append(due, i)
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return ms.orders[due[i]].NextCheckAt < ms.orders[due[j]].NextCheckAt
+	})
+
+	orders := make([]models.Order, 0)
+	for _, i := range due {
+		if len(orders) >= limit {
+			break
+		}
+		ms.orders[i].ClaimedBy = instanceID
+		ms.orders[i].ClaimedUntil = now.Add(lease).Unix()
+		orders = append(orders, ms.orders[i])
+	}
+	return orders, nil
+}
+
+func (ms *MemStorage) DispatchRenewClaim(ctx context.Context, number string, instanceID string, lease time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i := range ms.orders {
+		order := &ms.orders[i]
+		if order.Number != number || order.ClaimedBy != instanceID {
+			continue
+		}
+		if order.Status != models.OrderNew && order.Status != models.OrderProcessing {
+			continue
+		}
+		order.ClaimedUntil = time.Now().Add(lease).Unix()
+		return nil
+	}
+	return storage.ErrClaimLost
+}
+
+func (ms *MemStorage) DispatchRescheduleOrder(ctx context.Context, order models.Order) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i := range ms.orders {
+		if ms.orders[i].Number != order.Number {
+			continue
+		}
+		if ms.orders[i].Status != models.OrderNew && ms.orders[i].Status != models.OrderProcessing {
+			return storage.ErrOrderFinalized
+		}
+		if order.Status != "" {
+			ms.orders[i].Status = order.Status
+		}
+		ms.orders[i].NextCheckAt = order.NextCheckAt
+		ms.orders[i].Attempts = order.Attempts
+		return nil
+	}
+	return storage.ErrOrderFinalized
+}
+
+func (ms *MemStorage) DispatchDeferOrder(ctx context.Context, order models.Order) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i := range ms.orders {
+		if ms.orders[i].Number != order.Number {
+			continue
+		}
+		if ms.orders[i].Status != models.OrderNew && ms.orders[i].Status != models.OrderProcessing {
+			return storage.ErrOrderFinalized
+		}
+		if order.Status != "" {
+			ms.orders[i].Status = order.Status
+		}
+		ms.orders[i].NextCheckAt = order.NextCheckAt
+		return nil
+	}
+	return storage.ErrNoOrders
+}
+
+func (ms *MemStorage) DispatchMarkStuck(ctx context.Context, uploadedBefore int64) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var count int64
+	for i := range ms.orders {
+		status := ms.orders[i].Status
+		if (status == models.OrderNew || status == models.OrderProcessing) && ms.orders[i].UploadedAt < uploadedBefore {
+			ms.orders[i].Status = models.OrderStuck
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (ms *MemStorage) GetStuckOrders(ctx context.Context) ([]models.Order, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	orders := make([]models.Order, 0)
+	for _, order := range ms.orders {
+		if order.Status != models.OrderStuck {
+			continue
+		}
+		if user, ok := ms.userByID(order.UserID); ok {
+			order.User = &models.User{ID: user.ID, Login: user.Login}
+		}
+		orders = append(orders, order)
+	}
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].UploadedAt < orders[j].UploadedAt })
+	if len(orders) == 0 {
+		return orders, storage.ErrNoOrders
+	}
+	return orders, nil
+}
+
+func (ms *MemStorage) DispatchUpdateOrder(ctx context.Context, order models.Order) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i := range ms.orders {
+		if ms.orders[i].Number != order.Number {
+			continue
+		}
+		switch ms.orders[i].Status {
+		case models.OrderNew, models.OrderProcessing, models.OrderRegistered, models.OrderStuck:
+		default:
+			return storage.ErrOrderFinalized
+		}
+		if order.Status == models.OrderProcessed && order.Accrual > 0 {
+			orderID := ms.orders[i].ID
+			if err := ms.transfer(
+				models.LedgerTransaction{Kind: models.LedgerAccrual, Reference: order.Number, OrderID: &orderID},
+				ms.orders[i].UserID, models.SystemAccrual, order.Accrual,
+			); err != nil {
+				return err
+			}
+		}
+		if order.Status != "" {
+			ms.orders[i].Status = order.Status
+		}
+		if order.Accrual != 0 {
+			ms.orders[i].Accrual = order.Accrual
+		}
+		return nil
+	}
+	return storage.ErrNoOrders
+}
+
+func (ms *MemStorage) CreateSession(ctx context.Context, login string, session models.Session) (*models.Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	user, err := ms.user(login)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	session.ID = ms.nextID()
+	session.UserID = user.ID
+	session.CreatedAt = now
+	session.LastUsedAt = now
+	ms.sessions[session.ID] = session
+	return &session, nil
+}
+
+// active reports whether session is not revoked and not expired.
+func active(session models.Session, now int64) bool {
+	return session.RevokedAt == 0 && session.ExpiresAt > now
+}
+
+func (ms *MemStorage) GetSession(ctx context.Context, id uint) (*models.Session, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	session, ok := ms.sessions[id]
+	if !ok || !active(session, time.Now().Unix()) {
+		return nil, storage.ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (ms *MemStorage) RefreshSession(ctx context.Context, refreshHash string, session models.Session) (*models.User, *models.Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now().Unix()
+	for id, dbSession := range ms.sessions {
+		if dbSession.RefreshHash != refreshHash || !active(dbSession, now) {
+			continue
+		}
+		dbSession.RefreshHash = session.RefreshHash
+		dbSession.UserAgent = session.UserAgent
+		dbSession.IPAddress = session.IPAddress
+		dbSession.ExpiresAt = session.ExpiresAt
+		dbSession.LastUsedAt = now
+		ms.sessions[id] = dbSession
+		ms.usedRefresh[refreshHash] = id
+		user, _ := ms.userByID(dbSession.UserID)
+		return &user, &dbSession, nil
+	}
+	// повторно предъявленный токен, замененный ротацией, отзывает сессию
+	if id, ok := ms.usedRefresh[refreshHash]; ok {
+		if session := ms.sessions[id]; session.RevokedAt == 0 {
+			session.RevokedAt = now
+			ms.sessions[id] = session
+		}
+	}
+	return &models.User{}, &models.Session{}, storage.ErrInvalidRefreshToken
+}
+
+func (ms *MemStorage) GetSessions(ctx context.Context, login string) ([]models.Session, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	sessions := make([]models.Session, 0)
+	user, err := ms.user(login)
+	if err != nil {
+		return sessions, err
+	}
+	now := time.Now().Unix()
+	for _, session := range ms.sessions {
+		if session.UserID == user.ID && active(session, now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	return sessions, nil
+}
+
+func (ms *MemStorage) RevokeSession(ctx context.Context, login string, id uint) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	user, err := ms.user(login)
+	if err != nil {
+		return err
+	}
+	session, ok := ms.sessions[id]
+	if !ok || session.UserID != user.ID || session.RevokedAt != 0 {
+		return storage.ErrSessionNotFound
+	}
+	session.RevokedAt = time.Now().Unix()
+	ms.sessions[id] = session
+	return nil
+}
+
+func (ms *MemStorage) RevokeSessions(ctx context.Context, login string, exceptID uint) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	user, err := ms.user(login)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for id, session := range ms.sessions {
+		if session.UserID == user.ID && id != exceptID && session.RevokedAt == 0 {
+			session.RevokedAt = now
+			ms.sessions[id] = session
+		}
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package memstorage_test
+
+import (
+	"testing"
+
+	"github.com/hrapovd1/loyalty-account/internal/memstorage"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(*testing.T) storage.Storage {
+		return memstorage.NewMemStorage()
+	})
+}
@@ -0,0 +1,77 @@
+// Package metrics contains Prometheus collectors of application, they
+// are registered in default registry and exposed by Handler.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gophermart"
+
+var (
+	// HTTPRequestDuration is latency of API requests by route pattern.
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by method, route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+
+	// DispatcherBacklog is number of orders waiting for accrual by status.
+	DispatcherBacklog = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "dispatcher",
+		Name:      "orders",
+		Help:      "Number of orders waiting for accrual system by status.",
+	}, []string{"status"})
+
+	// AccrualResponses counts answers of accrual system by status code,
+	// code is "error" when request failed without answer.
+	AccrualResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "responses_total",
+		Help:      "Number of accrual system responses by status code.",
+	}, []string{"code"})
+
+	// AccrualCredited is sum of points credited for processed orders.
+	AccrualCredited = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "credited_points_total",
+		Help:      "Loyalty points credited for processed orders.",
+	})
+
+	// Withdrawals counts withdrawal requests by result.
+	Withdrawals = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "withdrawals_total",
+		Help:      "Number of withdrawals by result: ok, not_enough_funds or duplicate_order.",
+	}, []string{"result"})
+
+	// Withdrawn is sum of withdrawn points.
+	Withdrawn = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "withdrawn_points_total",
+		Help:      "Loyalty points withdrawn by users.",
+	})
+)
+
+// Results of withdrawal.
+const (
+	WithdrawalOK             = "ok"
+	WithdrawalNotEnoughFunds = "not_enough_funds"
+	WithdrawalDuplicate      = "duplicate_order"
+)
+
+// AccrualResponseError is code label of failed request to accrual system.
+const AccrualResponseError = "error"
+
+// Handler returns handler of /metrics endpoint.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -1,35 +1,184 @@
 package models
 
-import "database/sql"
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/hrapovd1/loyalty-account/internal/money"
+)
+
+var ErrImmutable = errors.New("record is immutable")
 
 type User struct {
-	ID        uint       `gorm:"primaryKey" json:"-"`
-	Login     string     `gorm:"uniqueIndex:idx_logins" json:"login"`
-	Password  string     `json:"password,omitempty"`
-	Account   Account    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"-"`
-	Orders    []Order    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"-"`
-	OrderLogs []OrderLog `json:"-"`
+	ID          uint         `gorm:"primaryKey" json:"-"`
+	Login       string       `gorm:"uniqueIndex:idx_logins" json:"login"`
+	Password    string       `json:"password,omitempty"`
+	Role        string       `gorm:"not null;default:user" json:"-"`
+	Account     Account      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"-"`
+	Orders      []Order      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"-"`
+	OrderLogs   []OrderLog   `json:"-"`
+	Sessions    []Session    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Adjustments []Adjustment `json:"-"`
 }
 
+// Account keeps balance cached from ledger entries, user accounts have
+// UserID and system accounts have Code instead.
 type Account struct {
 	ID      uint `gorm:"primaryKey"`
 	UserID  uint
-	Balance sql.NullFloat64
+	Code    *string      `gorm:"uniqueIndex:idx_account_codes"`
+	Balance money.Amount `gorm:"not null;default:0"`
 }
 
+// Statuses of orders, NEW and PROCESSING orders are polled
+// from accrual system until they get final status.
+const (
+	OrderNew        = "NEW"
+	OrderProcessing = "PROCESSING"
+	OrderInvalid    = "INVALID"
+	OrderProcessed  = "PROCESSED"
+	// OrderStuck is set to order which didn't get final status
+	// in time, it is shown to users as PROCESSING.
+	OrderStuck = "STUCK"
+	// OrderRegistered is a status of accrual system, which is not final.
+	OrderRegistered = "REGISTERED"
+)
+
+// Order is claimed by dispatcher instance ClaimedBy until
+// ClaimedUntil, so replicas don't poll the same orders. Order is not
+// polled before NextCheckAt, Attempts counts unsuccessful checks.
 type Order struct {
-	ID         uint    `gorm:"primaryKey" json:"-"`
-	UserID     uint    `json:"-"`
-	Number     string  `gorm:"uniqueIndex:idx_numbers,sort:desc" json:"number"`
-	Status     string  `json:"status"`
-	Accrual    float64 `json:"accrual,omitempty"`
-	UploadedAt int64   `gorm:"autoCreateTime" json:"uploaded_at"`
+	ID           uint         `gorm:"primaryKey;index:idx_orders_user_uploaded,priority:3" json:"-"`
+	UserID       uint         `gorm:"index:idx_orders_user_uploaded,priority:1" json:"-"`
+	Number       string       `gorm:"uniqueIndex:idx_numbers,sort:desc" json:"number"`
+	Status       string       `json:"status"`
+	Accrual      money.Amount `json:"accrual,omitempty"`
+	UploadedAt   int64        `gorm:"autoCreateTime;index:idx_orders_user_uploaded,priority:2" json:"uploaded_at"`
+	ClaimedBy    string       `json:"-"`
+	ClaimedUntil int64        `json:"-"`
+	NextCheckAt  int64        `json:"-"`
+	Attempts     int          `json:"-"`
+	User         *User        `json:"-"`
 }
 
+// OrderLog is a withdrawal for order. Reversed withdrawal stays in
+// history, but is not counted in withdrawn sum and its order may be
+// paid again.
 type OrderLog struct {
-	ID          uint    `gorm:"primaryKey" json:"-"`
-	UserID      uint    `json:"-"`
-	OrderNumber string  `json:"order"`
-	Sum         float64 `json:"sum"`
-	ProcessedAt int64   `gorm:"autoCreateTime" json:"processed_at"`
+	ID          uint         `gorm:"primaryKey;index:idx_order_logs_user_processed,priority:3" json:"-"`
+	UserID      uint         `gorm:"index:idx_order_logs_user_processed,priority:1" json:"-"`
+	OrderNumber string       `json:"order"`
+	Sum         money.Amount `json:"sum"`
+	ProcessedAt int64        `gorm:"autoCreateTime;index:idx_order_logs_user_processed,priority:2" json:"processed_at"`
+	Reversed    bool         `gorm:"not null;default:false" json:"-"`
+}
+
+type Session struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"index"`
+	RefreshHash string `gorm:"uniqueIndex:idx_refresh_hashes"`
+	UserAgent   string
+	IPAddress   string
+	CreatedAt   int64 `gorm:"autoCreateTime"`
+	LastUsedAt  int64
+	ExpiresAt   int64
+	RevokedAt   int64
+}
+
+// UsedRefreshToken is hash of refresh token replaced by rotation. Reuse
+// of it means that token is stolen, so its session is revoked.
+type UsedRefreshToken struct {
+	Hash      string `gorm:"primaryKey"`
+	SessionID uint   `gorm:"index"`
+}
+
+// IdempotencyKey keeps response to request made with Idempotency-Key
+// header, so retry of request gets the same response. Status is 0 while
+// the first request is in progress.
+type IdempotencyKey struct {
+	ID          uint `gorm:"primaryKey"`
+	UserID      uint
+	Key         string
+	RequestHash string
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   int64 `gorm:"autoCreateTime"`
+}
+
+// Adjustment is a manual balance change made by support,
+// positive Sum credits account and negative debits it.
+type Adjustment struct {
+	ID         uint `gorm:"primaryKey"`
+	UserID     uint `gorm:"index"`
+	AdminID    uint
+	AdminLogin string
+	Sum        money.Amount
+	ReasonCode string
+	Comment    string
+	CreatedAt  int64 `gorm:"autoCreateTime"`
+}
+
+func (Adjustment) BeforeUpdate(*gorm.DB) error {
+	return ErrImmutable
+}
+
+func (Adjustment) BeforeDelete(*gorm.DB) error {
+	return ErrImmutable
+}
+
+// Kinds of ledger transactions.
+const (
+	LedgerAccrual    = "accrual"
+	LedgerWithdrawal = "withdrawal"
+	LedgerAdjustment = "adjustment"
+	LedgerReversal   = "reversal"
+)
+
+// Codes of system accounts, which are counterparts of user accounts.
+const (
+	SystemAccrual    = "system:accrual"
+	SystemWithdrawal = "system:withdrawal"
+	SystemAdjustment = "system:adjustment"
+)
+
+// LedgerTransaction is an append-only posting, amounts of its entries
+// sum to zero. Reference is order number or adjustment id, ReversalOf
+// is id of transaction cancelled by reversal. OrderID is set for
+// accruals, there is at most one accrual per order.
+type LedgerTransaction struct {
+	ID         uint `gorm:"primaryKey"`
+	Kind       string
+	Reference  string
+	ReversalOf *uint
+	OrderID    *uint
+	CreatedAt  int64         `gorm:"autoCreateTime"`
+	Entries    []LedgerEntry `gorm:"foreignKey:TransactionID"`
+}
+
+// LedgerEntry is one side of transaction, positive Amount credits
+// account and negative debits it.
+type LedgerEntry struct {
+	ID            uint `gorm:"primaryKey"`
+	TransactionID uint `gorm:"index"`
+	AccountID     uint `gorm:"index"`
+	Amount        money.Amount
+	Transaction   *LedgerTransaction `gorm:"foreignKey:TransactionID"`
+}
+
+func (LedgerTransaction) BeforeUpdate(*gorm.DB) error {
+	return ErrImmutable
+}
+
+func (LedgerTransaction) BeforeDelete(*gorm.DB) error {
+	return ErrImmutable
+}
+
+func (LedgerEntry) BeforeUpdate(*gorm.DB) error {
+	return ErrImmutable
+}
+
+func (LedgerEntry) BeforeDelete(*gorm.DB) error {
+	return ErrImmutable
 }
@@ -0,0 +1,151 @@
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// scale is number of minor units in one loyalty point.
+const scale = 100
+
+var ErrPrecision = errors.New("amount has more than 2 decimal places")
+
+// decimalRe matches plain decimal number: sign, integer part and
+// fraction part, exponents and other bases are not accepted.
+var decimalRe = regexp.MustCompile(`^(-?)(\d+)(?:\.(\d+))?$`)
+
+// numberRe matches JSON number with exponent of at most 3 digits, so
+// conversion of it stays cheap.
+var numberRe = regexp.MustCompile(`^-?\d+(?:\.\d+)?(?:[eE][+-]?\d{1,3})?$`)
+
+// maxNumberLength limits length of number accepted by Round.
+const maxNumberLength = 64
+
+// Amount is a sum of loyalty points in minor units (hundredths), it is
+// stored in db as NUMERIC(20,2) and encoded in JSON as decimal number.
+type Amount int64
+
+// Parse returns amount from plain decimal string like "500", "42.5"
+// or "-0.01", amount with more than 2 decimal places is rejected.
+func Parse(s string) (Amount, error) {
+	match := decimalRe.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	sign, units, cents := match[1], match[2], match[3]
+	if len(cents) > 2 {
+		return 0, fmt.Errorf("%w: %q", ErrPrecision, s)
+	}
+	// дробная часть дополняется нулями до сотых: "42.5" -> 4250
+	minor, err := strconv.ParseInt(sign+units+cents+strings.Repeat("0", 2-len(cents)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("amount %q is out of range", s)
+	}
+	return Amount(minor), nil
+}
+
+// Round returns amount from decimal number of any precision, like
+// "3317.9993" or "1.5e2", rounded to hundredths half away from zero:
+// 3317.9993 is 3318, 0.005 is 0.01 and -0.005 is -0.01.
+func Round(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > maxNumberLength || !numberRe.MatchString(s) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	r.Mul(r, big.NewRat(scale, 1))
+	num, den := r.Num(), r.Denom()
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	// остаток не меньше половины делителя округляется от нуля
+	if rem.Sign() != 0 && new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(den) >= 0 {
+		quo.Add(quo, big.NewInt(int64(num.Sign())))
+	}
+	if !quo.IsInt64() {
+		return 0, fmt.Errorf("amount %q is out of range", s)
+	}
+	return Amount(quo.Int64()), nil
+}
+
+// String returns amount as decimal without trailing zeros: 500, 42.5, 0.01.
+func (a Amount) String() string {
+	sign := ""
+	u := uint64(a)
+	if a < 0 {
+		sign, u = "-", uint64(-a)
+	}
+	units, cents := u/scale, u%scale
+	if cents == 0 {
+		return sign + strconv.FormatUint(units, 10)
+	}
+	return strings.TrimRight(fmt.Sprintf("%s%d.%02d", sign, units, cents), "0")
+}
+
+// Float64 returns approximate value of amount, it must be used only
+// for reporting.
+func (a Amount) Float64() float64 {
+	return float64(a) / scale
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts JSON number or string with number.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	amount, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// Value passes amount to db as decimal string, so it is not
+// converted through float.
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+func (a *Amount) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+		return nil
+	case int64:
+		*a = Amount(v * scale)
+		return nil
+	case float64:
+		*a = Amount(math.Round(v * scale))
+		return nil
+	case []byte:
+		return a.scanString(string(v))
+	case string:
+		return a.scanString(v)
+	}
+	return fmt.Errorf("can't scan %T into money.Amount", src)
+}
+
+func (a *Amount) scanString(s string) error {
+	amount, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
@@ -0,0 +1,202 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr error
+	}{
+		{in: "500", want: 50000},
+		{in: "42.5", want: 4250},
+		{in: "0.01", want: 1},
+		{in: "729.98", want: 72998},
+		{in: "-0.5", want: -50},
+		{in: "-100", want: -10000},
+		{in: " 7 ", want: 700},
+		{in: "007.10", want: 710},
+		{in: "-0", want: 0},
+		{in: "0", want: 0},
+		{in: "0.001", wantErr: ErrPrecision},
+		{in: "1.005", wantErr: ErrPrecision},
+		{in: "-0.125", wantErr: ErrPrecision},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Parse(%q) error = %v, want %v", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"", "abc", "1.2.3", "--1", "+1", ".5", "5.", "1e3", "1.5E-1", "1e999999",
+		"1/2", "0x10", "0b1", "1_000", "92233720368547758.08", "99999999999999999999",
+	} {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) error = nil", in)
+		}
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+	}{
+		{"500", 50000},
+		{"729.98", 72998},
+		{"3317.9993", 331800},
+		{"3317.994", 331799},
+		{"0.005", 1},
+		{"0.0049", 0},
+		{"-0.005", -1},
+		{"-1.234", -123},
+		{"1e3", 100000},
+		{"1.5E-1", 15},
+		{"1e-5", 0},
+		{" 42.125 ", 4213},
+	}
+	for _, tt := range tests {
+		got, err := Round(tt.in)
+		if err != nil {
+			t.Errorf("Round(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Round(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"", "abc", "1/2", "0x10", "1e999999", "1e999", "+1", ".5",
+		"1" + strings.Repeat("0", 64),
+	} {
+		if _, err := Round(in); err == nil {
+			t.Errorf("Round(%q) error = nil", in)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		in   Amount
+		want string
+	}{
+		{0, "0"},
+		{1, "0.01"},
+		{10, "0.1"},
+		{4250, "42.5"},
+		{50000, "500"},
+		{72998, "729.98"},
+		{-1, "-0.01"},
+		{-50, "-0.5"},
+		{-10000, "-100"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+		if back, err := Parse(tt.want); err != nil || back != tt.in {
+			t.Errorf("Parse(%q) = %d, %v, want %d", tt.want, back, err, tt.in)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+	}{
+		{`500`, 50000},
+		{`0.01`, 1},
+		{`-42.5`, -4250},
+		{`"729.98"`, 72998},
+		{`"-0.5"`, -50},
+		{`null`, 0},
+	}
+	for _, tt := range tests {
+		var got struct {
+			Sum Amount `json:"sum"`
+		}
+		if err := json.Unmarshal([]byte(`{"sum":`+tt.in+`}`), &got); err != nil {
+			t.Errorf("Unmarshal(%s) error = %v", tt.in, err)
+			continue
+		}
+		if got.Sum != tt.want {
+			t.Errorf("Unmarshal(%s) = %d, want %d", tt.in, got.Sum, tt.want)
+		}
+	}
+
+	for _, in := range []string{`0.001`, `"0.001"`, `"abc"`, `true`, `1e3`, `"1/2"`} {
+		var got Amount
+		if err := json.Unmarshal([]byte(in), &got); err == nil {
+			t.Errorf("Unmarshal(%s) error = nil, amount %d", in, got)
+		}
+	}
+
+	data, err := json.Marshal(struct {
+		Sum Amount `json:"sum"`
+	}{Sum: 72998})
+	if err != nil {
+		t.Fatalf("Marshal error = %v", err)
+	}
+	if string(data) != `{"sum":729.98}` {
+		t.Errorf("Marshal = %s, want {\"sum\":729.98}", data)
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		src  interface{}
+		want Amount
+	}{
+		{nil, 0},
+		{int64(500), 50000},
+		{int64(-3), -300},
+		{float64(0.1), 10},
+		{float64(729.98), 72998},
+		{[]byte("729.98"), 72998},
+		{[]byte("0.01"), 1},
+		{[]byte("-42.50"), -4250},
+		{[]byte("100.00"), 10000},
+		{"500.00", 50000},
+	}
+	for _, tt := range tests {
+		got := Amount(1)
+		if err := got.Scan(tt.src); err != nil {
+			t.Errorf("Scan(%#v) error = %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Scan(%#v) = %d, want %d", tt.src, got, tt.want)
+		}
+	}
+
+	for _, src := range []interface{}{[]byte("0.001"), "abc", true} {
+		var got Amount
+		if err := got.Scan(src); err == nil {
+			t.Errorf("Scan(%#v) error = nil", src)
+		}
+	}
+
+	value, err := Amount(-4250).Value()
+	if err != nil || value != "-42.5" {
+		t.Errorf("Value() = %#v, %v, want \"-42.5\"", value, err)
+	}
+}
@@ -0,0 +1,375 @@
+// Package contracttest is a contract suite of application API against
+// OpenAPI document. It checks that every route of router is documented
+// and every documented operation is routed, then runs scenario calling
+// every operation and validates each response by document:
+//
+//	func TestContract(t *testing.T) {
+//		contracttest.Run(t, func(t *testing.T) (*chi.Mux, storage.Storage) {
+//			store := memstorage.NewMemStorage()
+//			conf := config.Config{
+//				PasswordHasher:       "bcrypt",
+//				JWTSecret:            "secret",
+//				AccrualWebhookSecret: contracttest.WebhookSecret,
+//				AccrualPushTimeout:   time.Minute,
+//				IdempotencyTTL:       time.Hour,
+//			}
+//			app, err := handlers.NewAppHandler(conf, store, slog.Default())
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return handlers.NewRouter(app), store
+//		})
+//	}
+package contracttest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/hrapovd1/loyalty-account/internal/auth"
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/openapi"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+)
+
+// WebhookSecret must be accrual webhook secret of application under
+// test, webhook is routed only when secret is set.
+const WebhookSecret = "contract-webhook-secret"
+
+// Factory returns router of new application with empty storage,
+// storage is used to prepare data, which can't be made through API.
+type Factory func(t *testing.T) (*chi.Mux, storage.Storage)
+
+// Run runs contract suite against application made by newApp.
+func Run(t *testing.T, newApp Factory) {
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatalf("openapi.Load: %v", err)
+	}
+	api, err := legacy.NewRouter(doc)
+	if err != nil {
+		t.Fatalf("legacy.NewRouter: %v", err)
+	}
+
+	t.Run("Routes", func(t *testing.T) {
+		mux, store := newApp(t)
+		t.Cleanup(func() { store.Close() })
+		testRoutes(t, doc, mux)
+	})
+	t.Run("Scenario", func(t *testing.T) {
+		mux, store := newApp(t)
+		t.Cleanup(func() { store.Close() })
+		c := &client{t: t, handler: mux, api: api, called: make(map[string]bool)}
+		scenario(t, c, store)
+
+		// Каждая операция документа должна быть проверена сценарием.
+		for _, operation := range operations(doc) {
+			if !c.called[operation] {
+				t.Errorf("operation %s is not called by scenario", operation)
+			}
+		}
+	})
+}
+
+// pathParam matches parameter of path template.
+var pathParam = regexp.MustCompile(`\{[^}]+\}`)
+
+// testRoutes checks that routes of mux and paths of document match.
+func testRoutes(t *testing.T, doc *openapi3.T, mux *chi.Mux) {
+	err := chi.Walk(mux, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if doc.Paths.Find(route) == nil {
+			t.Errorf("route %s %s is not documented", method, route)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("chi.Walk: %v", err)
+	}
+	for _, operation := range operations(doc) {
+		method, path, _ := strings.Cut(operation, " ")
+		path = pathParam.ReplaceAllString(path, "1")
+		if !mux.Match(chi.NewRouteContext(), method, path) {
+			t.Errorf("operation %s is not routed", operation)
+		}
+	}
+}
+
+// operations returns "METHOD path" of every operation of document.
+func operations(doc *openapi3.T) []string {
+	var result []string
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			result = append(result, method+" "+path)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// client calls application and validates responses by document.
+type client struct {
+	t       *testing.T
+	handler http.Handler
+	api     routers.Router
+	called  map[string]bool
+}
+
+// request is call of API.
+type request struct {
+	method      string
+	path        string
+	contentType string
+	body        string
+	token       string
+	header      map[string]string
+}
+
+// do calls application, checks status of response and validates it
+// by document.
+func (c *client) do(req request, want int) *httptest.ResponseRecorder {
+	c.t.Helper()
+	r := c.newRequest(req)
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, r)
+
+	name := req.method + " " + req.path
+	if rec.Code != want {
+		c.t.Errorf("%s: status %d, want %d, body %s", name, rec.Code, want, rec.Body.String())
+	}
+
+	// Запрос уже прочитан обработчиком, маршрут ищется по копии.
+	r = c.newRequest(req)
+	route, pathParams, err := c.api.FindRoute(r)
+	if err != nil {
+		c.t.Errorf("%s: operation is not documented: %v", name, err)
+		return rec
+	}
+	c.called[route.Method+" "+route.Path] = true
+
+	input := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+		},
+		Status: rec.Code,
+		Header: rec.Header(),
+		Options: &openapi3filter.Options{
+			IncludeResponseStatus: true,
+			AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+		},
+	}
+	input.SetBodyBytes(rec.Body.Bytes())
+	if err := openapi3filter.ValidateResponse(context.Background(), input); err != nil {
+		c.t.Errorf("%s: response %d doesn't match document: %v", name, rec.Code, err)
+	}
+	return rec
+}
+
+func (c *client) newRequest(req request) *http.Request {
+	var body io.Reader
+	if req.body != "" {
+		body = strings.NewReader(req.body)
+	}
+	r := httptest.NewRequest(req.method, req.path, body)
+	if req.contentType != "" {
+		r.Header.Set("Content-Type", req.contentType)
+	}
+	if req.token != "" {
+		r.Header.Set("Authorization", req.token)
+	}
+	for name, value := range req.header {
+		r.Header.Set(name, value)
+	}
+	return r
+}
+
+// register creates user and returns its access token.
+func (c *client) register(login string) string {
+	c.t.Helper()
+	rec := c.do(request{
+		method: http.MethodPost, path: "/api/user/register", contentType: "application/json",
+		body: fmt.Sprintf(`{"login":%q,"password":"secret"}`, login),
+	}, http.StatusOK)
+	return rec.Header().Get("Authorization")
+}
+
+// login opens new session of user and returns tokens.
+func (c *client) login(login string) (string, string) {
+	c.t.Helper()
+	rec := c.do(request{
+		method: http.MethodPost, path: "/api/user/login", contentType: "application/json",
+		body: fmt.Sprintf(`{"login":%q,"password":"secret"}`, login),
+	}, http.StatusOK)
+	var tokens struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &tokens)
+	return rec.Header().Get("Authorization"), tokens.RefreshToken
+}
+
+// push sends signed accrual webhook.
+func (c *client) push(body string, want int) {
+	c.t.Helper()
+	ts := time.Now().Unix()
+	c.do(request{
+		method: http.MethodPost, path: "/api/accrual/webhook", contentType: "application/json", body: body,
+		header: map[string]string{
+			"X-Accrual-Timestamp": strconv.FormatInt(ts, 10),
+			"X-Accrual-Signature": auth.SignWebhook([]byte(WebhookSecret), ts, []byte(body)),
+		},
+	}, want)
+}
+
+func scenario(t *testing.T, c *client, store storage.Storage) {
+	const (
+		get      = http.MethodGet
+		post     = http.MethodPost
+		put      = http.MethodPut
+		delete   = http.MethodDelete
+		jsonType = "application/json"
+		text     = "text/plain"
+	)
+
+	// Служебные маршруты.
+	c.do(request{method: get, path: "/openapi.json"}, http.StatusOK)
+	c.do(request{method: get, path: "/healthz"}, http.StatusOK)
+	c.do(request{method: get, path: "/readyz"}, http.StatusOK)
+	c.do(request{method: get, path: "/metrics"}, http.StatusOK)
+	c.do(request{method: get, path: "/.well-known/jwks.json"}, http.StatusOK)
+
+	// Регистрация и вход.
+	alice := c.register("alice")
+	c.do(request{method: post, path: "/api/user/register", contentType: jsonType, body: `{"login":"alice","password":"secret"}`}, http.StatusConflict)
+	c.do(request{method: post, path: "/api/user/register", contentType: jsonType, body: `{"login":"alice"`}, http.StatusBadRequest)
+	c.do(request{method: post, path: "/api/user/register", contentType: text, body: `{"login":"carol","password":"secret"}`}, http.StatusUnsupportedMediaType)
+	c.do(request{method: post, path: "/api/user/login", contentType: jsonType, body: `{"login":"alice","password":"wrong"}`}, http.StatusUnauthorized)
+	_, refresh := c.login("alice")
+	c.do(request{method: post, path: "/api/user/token/refresh", contentType: jsonType, body: fmt.Sprintf(`{"refresh_token":%q}`, refresh)}, http.StatusOK)
+	c.do(request{method: post, path: "/api/user/token/refresh", contentType: jsonType, body: fmt.Sprintf(`{"refresh_token":%q}`, refresh)}, http.StatusUnauthorized)
+
+	// Заказы.
+	c.do(request{method: get, path: "/api/user/orders", token: "wrong"}, http.StatusUnauthorized)
+	c.do(request{method: get, path: "/api/user/orders", token: alice}, http.StatusNoContent)
+	c.do(request{method: post, path: "/api/user/orders", contentType: text, body: "12345678903", token: alice}, http.StatusAccepted)
+	c.do(request{method: post, path: "/api/user/orders", contentType: text, body: "12345678903", token: alice}, http.StatusOK)
+	c.do(request{method: post, path: "/api/user/orders", contentType: text, body: "12345678904", token: alice}, http.StatusUnprocessableEntity)
+	c.do(request{method: post, path: "/api/user/orders", contentType: text, token: alice}, http.StatusBadRequest)
+	c.do(request{method: post, path: "/api/user/orders", contentType: text, body: "2377225624", token: alice}, http.StatusAccepted)
+	bob := c.register("bob")
+	c.do(request{method: post, path: "/api/user/orders", contentType: text, body: "12345678903", token: bob}, http.StatusConflict)
+
+	// Уведомления системы начисления.
+	c.push(`{"order":"12345678903","status":"PROCESSED","accrual":500}`, http.StatusOK)
+	c.push(`{"order":"79927398713","status":"PROCESSED","accrual":500}`, http.StatusNotFound)
+	c.push(`{"order":"2377225624","status":"LOST"}`, http.StatusUnprocessableEntity)
+	c.do(request{
+		method: post, path: "/api/accrual/webhook", contentType: jsonType, body: `{"order":"2377225624","status":"INVALID"}`,
+		header: map[string]string{"X-Accrual-Timestamp": "0", "X-Accrual-Signature": "sha256=00"},
+	}, http.StatusUnauthorized)
+
+	rec := c.do(request{method: get, path: "/api/user/orders?limit=1&sort=desc", token: alice}, http.StatusOK)
+	if rec.Header().Get("Link") == "" {
+		t.Errorf("GET /api/user/orders?limit=1: Link of next page is missing")
+	}
+	c.do(request{method: get, path: "/api/user/orders?limit=0", token: alice}, http.StatusBadRequest)
+	c.do(request{method: get, path: "/api/user/orders?status=PROCESSED&from=2020-01-01T00:00:00Z", token: alice}, http.StatusOK)
+
+	// Баланс и списания.
+	c.do(request{method: get, path: "/api/user/balance", token: alice}, http.StatusOK)
+	c.do(request{method: post, path: "/api/user/balance/withdraw", contentType: jsonType, body: `{"order":"79927398713","sum":100}`, token: alice}, http.StatusOK)
+	c.do(request{method: post, path: "/api/user/balance/withdraw", contentType: jsonType, body: `{"order":"79927398713","sum":100}`, token: alice}, http.StatusConflict)
+	c.do(request{method: post, path: "/api/user/balance/withdraw", contentType: jsonType, body: `{"order":"49927398716","sum":100000}`, token: alice}, http.StatusPaymentRequired)
+	c.do(request{method: post, path: "/api/user/balance/withdraw", contentType: jsonType, body: `{"order":"12345","sum":1}`, token: alice}, http.StatusUnprocessableEntity)
+	// время списания задает сервер
+	c.do(request{method: post, path: "/api/user/balance/withdraw", contentType: jsonType, body: `{"order":"49927398716","sum":1,"processed_at":"2020-01-01T00:00:00Z"}`, token: alice}, http.StatusBadRequest)
+	idempotent := request{
+		method: post, path: "/api/user/balance/withdraw", contentType: jsonType, body: `{"order":"4561261212345467","sum":50}`, token: alice,
+		header: map[string]string{"Idempotency-Key": "withdraw-1"},
+	}
+	c.do(idempotent, http.StatusOK)
+	if rec := c.do(idempotent, http.StatusOK); rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry with Idempotency-Key: response is not replayed")
+	}
+	idempotent.body = `{"order":"4561261212345467","sum":60}`
+	c.do(idempotent, http.StatusConflict)
+	c.do(request{method: get, path: "/api/user/withdrawals", token: alice}, http.StatusOK)
+	c.do(request{method: get, path: "/api/user/withdrawals?sort=up", token: alice}, http.StatusBadRequest)
+	c.do(request{method: get, path: "/api/user/adjustments", token: alice}, http.StatusNoContent)
+	rec = c.do(request{method: get, path: "/api/user/ledger", token: alice}, http.StatusOK)
+	var ledger []struct {
+		TransactionID uint   `json:"transaction_id"`
+		Kind          string `json:"kind"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &ledger)
+	var withdrawal uint
+	for _, entry := range ledger {
+		if entry.Kind == models.LedgerWithdrawal {
+			withdrawal = entry.TransactionID
+		}
+	}
+
+	// Сессии.
+	c.do(request{method: get, path: "/api/user/sessions", token: alice}, http.StatusOK)
+	c.do(request{method: delete, path: "/api/user/sessions/999999", token: alice}, http.StatusNotFound)
+	c.do(request{method: delete, path: "/api/user/sessions/first", token: alice}, http.StatusBadRequest)
+	c.do(request{method: delete, path: "/api/user/sessions", token: alice}, http.StatusOK)
+
+	// API поддержки.
+	c.do(request{method: get, path: "/api/admin/users/alice", token: alice}, http.StatusForbidden)
+	c.register("root")
+	if err := store.SetUserRole(context.Background(), "root", auth.RoleAdmin); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+	root, _ := c.login("root")
+	c.do(request{method: get, path: "/api/admin/users/alice", token: root}, http.StatusOK)
+	c.do(request{method: get, path: "/api/admin/users/nobody", token: root}, http.StatusNotFound)
+	c.do(request{method: get, path: "/api/admin/users/alice/orders", token: root}, http.StatusOK)
+	c.do(request{method: get, path: "/api/admin/users/alice/withdrawals", token: root}, http.StatusOK)
+	c.do(request{method: get, path: "/api/admin/users/alice/balance", token: root}, http.StatusOK)
+	c.do(request{method: get, path: "/api/admin/users/alice/adjustments", token: root}, http.StatusNoContent)
+	c.do(request{method: post, path: "/api/admin/users/alice/adjustments", contentType: jsonType, body: `{"sum":10,"reason":"goodwill","comment":"gift"}`, token: root}, http.StatusOK)
+	c.do(request{method: post, path: "/api/admin/users/alice/adjustments", contentType: jsonType, body: `{"sum":10}`, token: root}, http.StatusUnprocessableEntity)
+	c.do(request{method: get, path: "/api/admin/users/alice/adjustments", token: root}, http.StatusOK)
+	c.do(request{method: get, path: "/api/user/adjustments", token: alice}, http.StatusOK)
+	c.do(request{method: get, path: "/api/admin/users/alice/ledger", token: root}, http.StatusOK)
+	reverse := fmt.Sprintf("/api/admin/ledger/%d/reverse", withdrawal)
+	c.do(request{method: post, path: reverse, token: root}, http.StatusOK)
+	c.do(request{method: post, path: reverse, token: root}, http.StatusConflict)
+	c.do(request{method: post, path: "/api/admin/ledger/999999/reverse", token: root}, http.StatusNotFound)
+	c.do(request{method: get, path: "/api/admin/orders/stuck", token: root}, http.StatusNoContent)
+	c.do(request{method: put, path: "/api/admin/users/bob/role", contentType: jsonType, body: `{"role":"support"}`, token: root}, http.StatusOK)
+	c.do(request{method: put, path: "/api/admin/users/bob/role", contentType: jsonType, body: `{"role":"boss"}`, token: root}, http.StatusBadRequest)
+	c.do(request{method: put, path: "/api/admin/users/nobody/role", contentType: jsonType, body: `{"role":"user"}`, token: root}, http.StatusNotFound)
+	// Смена роли завершает сессии пользователя, старая роль больше не действует.
+	c.register("chief")
+	if err := store.SetUserRole(context.Background(), "chief", auth.RoleAdmin); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+	chief, _ := c.login("chief")
+	c.do(request{method: get, path: "/api/admin/users/alice", token: chief}, http.StatusOK)
+	c.do(request{method: put, path: "/api/admin/users/chief/role", contentType: jsonType, body: `{"role":"user"}`, token: root}, http.StatusOK)
+	c.do(request{method: get, path: "/api/admin/users/alice", token: chief}, http.StatusUnauthorized)
+	chief, _ = c.login("chief")
+	c.do(request{method: get, path: "/api/admin/users/alice", token: chief}, http.StatusForbidden)
+
+	// Завершение сессии.
+	c.do(request{method: post, path: "/api/user/logout", token: alice}, http.StatusOK)
+	c.do(request{method: get, path: "/api/user/balance", token: alice}, http.StatusUnauthorized)
+}
@@ -0,0 +1,54 @@
+// Package openapi contains OpenAPI 3 document of application API, it
+// is embedded to binary, served by Handler and used to validate
+// requests and, in contract suite, responses.
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+)
+
+//go:embed openapi.json
+var document []byte
+
+// Document returns OpenAPI document in JSON.
+func Document() []byte {
+	return document
+}
+
+// Load parses and validates OpenAPI document.
+func Load() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(document)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// NewRouter returns router, which finds operation of document by
+// request.
+func NewRouter() (routers.Router, error) {
+	doc, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return legacy.NewRouter(doc)
+}
+
+// Handler returns handler of /openapi.json endpoint.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		// документ отдается как есть, ошибку записи передать некому
+		_, _ = rw.Write(document)
+	})
+}
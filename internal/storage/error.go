@@ -0,0 +1,23 @@
+package storage
+
+import "errors"
+
+var ErrUserAlreadyExists = errors.New("user with such credentials already exist")
+var ErrUserNotFound = errors.New("user not found")
+var ErrInvalidLoginPassword = errors.New("invalid login/password")
+var ErrOrderExists = errors.New("order early uploaded")
+var ErrOrderExistsAnother = errors.New("order early uploaded another user")
+var ErrNoOrders = errors.New("orders not found")
+var ErrOrderFinalized = errors.New("order already has final status")
+var ErrNoAdjustments = errors.New("adjustments not found")
+var ErrNotEnoughFunds = errors.New("not enough funds")
+var ErrSessionNotFound = errors.New("session not found")
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+var ErrTransactionNotFound = errors.New("ledger transaction not found")
+var ErrAlreadyReversed = errors.New("ledger transaction already reversed")
+var ErrNotReversible = errors.New("ledger transaction can't be reversed")
+var ErrUnbalanced = errors.New("ledger transaction is unbalanced")
+var ErrMigrationsPending = errors.New("database migrations are not applied")
+var ErrWithdrawalExists = errors.New("order already paid with accrual")
+var ErrIdempotencyKeyExists = errors.New("idempotency key already used")
+var ErrClaimLost = errors.New("order is not claimed by instance")
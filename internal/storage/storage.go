@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+)
+
+// Storage is persistent state of application, implemented by
+// dbstorage.DBStorage and memstorage.MemStorage.
+type Storage interface {
+	Close() error
+	Ready(ctx context.Context) error
+
+	CreateUser(ctx context.Context, user models.User) error
+	GetUser(ctx context.Context, login string) (*models.User, error)
+	UpdateUserPassword(ctx context.Context, login string, password string) error
+	SetUserRole(ctx context.Context, login string, role string) error
+
+	CreateOrder(ctx context.Context, login string, order models.Order) error
+	GetOrders(ctx context.Context, login string, query types.ListQuery) ([]models.Order, error)
+	GetBalance(ctx context.Context, login string) (*types.Balance, error)
+	WithdrawOrder(ctx context.Context, login string, orderLog models.OrderLog) error
+	GetOrderLogs(ctx context.Context, login string, query types.ListQuery) ([]models.OrderLog, error)
+	AdjustBalance(ctx context.Context, login string, adminLogin string, adjustment models.Adjustment) (*models.Adjustment, error)
+	GetAdjustments(ctx context.Context, login string) ([]models.Adjustment, error)
+
+	GetLedger(ctx context.Context, login string) ([]models.LedgerEntry, error)
+	ReverseTransaction(ctx context.Context, id uint) (*models.LedgerTransaction, error)
+	CheckLedger(ctx context.Context) ([]types.LedgerDiscrepancy, error)
+
+	DispatchGetOrders(ctx context.Context, status string) ([]string, error)
+	DispatchClaimOrders(ctx context.Context, instanceID string, lease time.Duration, limit int) ([]models.Order, error)
+	DispatchRenewClaim(ctx context.Context, number string, instanceID string, lease time.Duration) error
+	DispatchRescheduleOrder(ctx context.Context, order models.Order) error
+	DispatchDeferOrder(ctx context.Context, order models.Order) error
+	DispatchMarkStuck(ctx context.Context, uploadedBefore int64) (int64, error)
+	GetStuckOrders(ctx context.Context) ([]models.Order, error)
+	DispatchUpdateOrder(ctx context.Context, order models.Order) error
+
+	CreateSession(ctx context.Context, login string, session models.Session) (*models.Session, error)
+	GetSession(ctx context.Context, id uint) (*models.Session, error)
+	RefreshSession(ctx context.Context, refreshHash string, session models.Session) (*models.User, *models.Session, error)
+	GetSessions(ctx context.Context, login string) ([]models.Session, error)
+	RevokeSession(ctx context.Context, login string, id uint) error
+	RevokeSessions(ctx context.Context, login string, exceptID uint) error
+
+	CreateIdempotencyKey(ctx context.Context, login string, key models.IdempotencyKey, expiredBefore int64) (*models.IdempotencyKey, error)
+	CompleteIdempotencyKey(ctx context.Context, key models.IdempotencyKey) error
+	DeleteIdempotencyKey(ctx context.Context, id uint) error
+}
@@ -0,0 +1,861 @@
+// Package storagetest is a conformance suite for storage.Storage
+// implementations. Every implementation must pass it:
+//
+//	func TestStorage(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.Storage {
+//			return memstorage.NewMemStorage()
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/money"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
+	"github.com/hrapovd1/loyalty-account/internal/types"
+)
+
+// Суммы в тестах заданы в сотых долях балла, как хранит их money.Amount.
+
+// Factory returns new empty storage for every subtest.
+type Factory func(t *testing.T) storage.Storage
+
+// Run runs conformance suite against storage made by newStorage.
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store storage.Storage)
+	}{
+		{"Users", testUsers},
+		{"NewUser", testNewUser},
+		{"Orders", testOrders},
+		{"Withdraw", testWithdraw},
+		{"Pages", testPages},
+		{"Adjustments", testAdjustments},
+		{"Dispatch", testDispatch},
+		{"AccrualOnce", testAccrualOnce},
+		{"Claims", testClaims},
+		{"Backoff", testBackoff},
+		{"Defer", testDefer},
+		{"Ledger", testLedger},
+		{"Sessions", testSessions},
+		{"Idempotency", testIdempotency},
+		{"ConcurrentWithdraw", testConcurrentWithdraw},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			store := newStorage(t)
+			t.Cleanup(func() { store.Close() })
+			tt.fn(t, store)
+		})
+	}
+}
+
+// createUser registers user the way Register handler does, storage
+// must create account with zero balance for the user itself.
+func createUser(t *testing.T, store storage.Storage, login string) {
+	t.Helper()
+	err := store.CreateUser(context.Background(), models.User{
+		Login:    login,
+		Password: "hash",
+	})
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %v", login, err)
+	}
+}
+
+// credit adds sum to user balance through accrual of new order.
+func credit(t *testing.T, store storage.Storage, login string, number string, sum money.Amount) {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.CreateOrder(ctx, login, models.Order{Number: number, Status: "NEW"}); err != nil {
+		t.Fatalf("CreateOrder(%q): %v", number, err)
+	}
+	if err := store.DispatchUpdateOrder(ctx, models.Order{Number: number, Status: "PROCESSED", Accrual: sum}); err != nil {
+		t.Fatalf("DispatchUpdateOrder(%q): %v", number, err)
+	}
+}
+
+func checkBalance(t *testing.T, store storage.Storage, login string, current, withdrawn money.Amount) {
+	t.Helper()
+	balance, err := store.GetBalance(context.Background(), login)
+	if err != nil {
+		t.Fatalf("GetBalance(%q): %v", login, err)
+	}
+	if balance.Balance != current || balance.Summ != withdrawn {
+		t.Errorf("GetBalance(%q) = %v/%v, want %v/%v", login, balance.Balance, balance.Summ, current, withdrawn)
+	}
+}
+
+func testUsers(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+
+	if err := store.CreateUser(ctx, models.User{Login: "alice", Password: "other"}); !errors.Is(err, storage.ErrUserAlreadyExists) {
+		t.Errorf("CreateUser duplicate: got %v, want %v", err, storage.ErrUserAlreadyExists)
+	}
+	if _, err := store.GetUser(ctx, "bob"); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("GetUser unknown: got %v, want %v", err, storage.ErrUserNotFound)
+	}
+
+	user, err := store.GetUser(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if user.ID == 0 || user.Login != "alice" || user.Password != "hash" || user.Role != "user" {
+		t.Errorf("GetUser = %+v", user)
+	}
+
+	if err := store.UpdateUserPassword(ctx, "alice", "new hash"); err != nil {
+		t.Fatalf("UpdateUserPassword: %v", err)
+	}
+	if err := store.SetUserRole(ctx, "alice", "admin"); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+	if err := store.SetUserRole(ctx, "bob", "admin"); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("SetUserRole unknown: got %v, want %v", err, storage.ErrUserNotFound)
+	}
+	user, err = store.GetUser(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if user.Password != "new hash" || user.Role != "admin" {
+		t.Errorf("GetUser after update = %+v", user)
+	}
+	checkBalance(t, store, "alice", 0, 0)
+}
+
+// testNewUser checks that account of just registered user takes
+// accruals and withdrawals without any extra setup.
+func testNewUser(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	checkBalance(t, store, "alice", 0, 0)
+
+	credit(t, store, "alice", "12345678903", 500)
+	checkBalance(t, store, "alice", 500, 0)
+	if err := store.WithdrawOrder(ctx, "alice", models.OrderLog{OrderNumber: "2377225624", Sum: 200}); err != nil {
+		t.Fatalf("WithdrawOrder: %v", err)
+	}
+	checkBalance(t, store, "alice", 300, 200)
+	checkLedger(t, store)
+}
+
+func testOrders(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createUser(t, store, "bob")
+
+	if _, err := store.GetOrders(ctx, "alice", types.ListQuery{}); !errors.Is(err, storage.ErrNoOrders) {
+		t.Errorf("GetOrders empty: got %v, want %v", err, storage.ErrNoOrders)
+	}
+	for _, number := range []string{"12345678903", "9278923470"} {
+		if err := store.CreateOrder(ctx, "alice", models.Order{Number: number, Status: "NEW"}); err != nil {
+			t.Fatalf("CreateOrder(%q): %v", number, err)
+		}
+	}
+	if err := store.CreateOrder(ctx, "alice", models.Order{Number: "12345678903", Status: "NEW"}); !errors.Is(err, storage.ErrOrderExists) {
+		t.Errorf("CreateOrder same user: got %v, want %v", err, storage.ErrOrderExists)
+	}
+	if err := store.CreateOrder(ctx, "bob", models.Order{Number: "12345678903", Status: "NEW"}); !errors.Is(err, storage.ErrOrderExistsAnother) {
+		t.Errorf("CreateOrder another user: got %v, want %v", err, storage.ErrOrderExistsAnother)
+	}
+
+	orders, err := store.GetOrders(ctx, "alice", types.ListQuery{})
+	if err != nil {
+		t.Fatalf("GetOrders: %v", err)
+	}
+	if len(orders) != 2 {
+		t.Fatalf("GetOrders returned %d orders, want 2", len(orders))
+	}
+	for _, order := range orders {
+		if order.Status != "NEW" || order.UploadedAt == 0 {
+			t.Errorf("GetOrders order = %+v", order)
+		}
+	}
+	if _, err := store.GetOrders(ctx, "bob", types.ListQuery{}); !errors.Is(err, storage.ErrNoOrders) {
+		t.Errorf("GetOrders of another user: got %v, want %v", err, storage.ErrNoOrders)
+	}
+}
+
+func testWithdraw(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	credit(t, store, "alice", "12345678903", 10000)
+	checkBalance(t, store, "alice", 10000, 0)
+
+	if _, err := store.GetOrderLogs(ctx, "alice", types.ListQuery{}); !errors.Is(err, storage.ErrNoOrders) {
+		t.Errorf("GetOrderLogs empty: got %v, want %v", err, storage.ErrNoOrders)
+	}
+	if err := store.WithdrawOrder(ctx, "alice", models.OrderLog{OrderNumber: "2377225624", Sum: 0}); err == nil {
+		t.Errorf("WithdrawOrder zero sum: got nil error")
+	}
+	if err := store.WithdrawOrder(ctx, "alice", models.OrderLog{OrderNumber: "2377225624", Sum: 10050}); !errors.Is(err, storage.ErrNotEnoughFunds) {
+		t.Errorf("WithdrawOrder over balance: got %v, want %v", err, storage.ErrNotEnoughFunds)
+	}
+	// время списания задает хранилище, переданное игнорируется
+	if err := store.WithdrawOrder(ctx, "alice", models.OrderLog{OrderNumber: "2377225624", Sum: 4000, ProcessedAt: 1}); err != nil {
+		t.Fatalf("WithdrawOrder: %v", err)
+	}
+	checkBalance(t, store, "alice", 6000, 4000)
+	if err := store.WithdrawOrder(ctx, "alice", models.OrderLog{OrderNumber: "2377225624", Sum: 1000}); !errors.Is(err, storage.ErrWithdrawalExists) {
+		t.Errorf("WithdrawOrder same order: got %v, want %v", err, storage.ErrWithdrawalExists)
+	}
+	checkBalance(t, store, "alice", 6000, 4000)
+
+	orderLogs, err := store.GetOrderLogs(ctx, "alice", types.ListQuery{})
+	if err != nil {
+		t.Fatalf("GetOrderLogs: %v", err)
+	}
+	if len(orderLogs) != 1 || orderLogs[0].OrderNumber != "2377225624" || orderLogs[0].Sum != 4000 || orderLogs[0].ProcessedAt < time.Now().Add(-time.Minute).Unix() {
+		t.Errorf("GetOrderLogs = %+v", orderLogs)
+	}
+}
+
+func testPages(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	numbers := []string{"12345678903", "2377225624", "4561261212345467", "79927398713", "49927398716"}
+	for _, number := range numbers {
+		if err := store.CreateOrder(ctx, "alice", models.Order{Number: number, Status: "NEW"}); err != nil {
+			t.Fatalf("CreateOrder(%q): %v", number, err)
+		}
+	}
+	if err := store.DispatchUpdateOrder(ctx, models.Order{Number: numbers[1], Status: "INVALID"}); err != nil {
+		t.Fatalf("DispatchUpdateOrder: %v", err)
+	}
+
+	// Постраничный обход в обоих направлениях возвращает все заказы
+	// по одному разу в порядке загрузки.
+	for _, desc := range []bool{false, true} {
+		var got []string
+		query := types.ListQuery{Desc: desc, Limit: 2}
+		for pages := 0; pages < 10; pages++ {
+			orders, err := store.GetOrders(ctx, "alice", query)
+			if errors.Is(err, storage.ErrNoOrders) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("GetOrders(%+v): %v", query, err)
+			}
+			if len(orders) > 2 {
+				t.Fatalf("GetOrders(%+v) returned %d orders, want at most 2", query, len(orders))
+			}
+			for _, order := range orders {
+				got = append(got, order.Number)
+			}
+			last := orders[len(orders)-1]
+			query.After = &types.Cursor{Time: last.UploadedAt, ID: last.ID}
+		}
+		if len(got) != len(numbers) {
+			t.Fatalf("pages desc=%v = %v, want %d orders", desc, got, len(numbers))
+		}
+		for i := range got {
+			want := numbers[i]
+			if desc {
+				want = numbers[len(numbers)-1-i]
+			}
+			if got[i] != want {
+				t.Errorf("pages desc=%v = %v, want order %v", desc, got, numbers)
+				break
+			}
+		}
+	}
+
+	orders, err := store.GetOrders(ctx, "alice", types.ListQuery{Statuses: []string{"INVALID"}})
+	if err != nil {
+		t.Fatalf("GetOrders by status: %v", err)
+	}
+	if len(orders) != 1 || orders[0].Number != numbers[1] {
+		t.Errorf("GetOrders by status = %+v", orders)
+	}
+	now := time.Now().Unix()
+	if _, err := store.GetOrders(ctx, "alice", types.ListQuery{From: now + 60}); !errors.Is(err, storage.ErrNoOrders) {
+		t.Errorf("GetOrders from future: got %v, want %v", err, storage.ErrNoOrders)
+	}
+	if orders, err := store.GetOrders(ctx, "alice", types.ListQuery{From: now - 60, To: now + 60}); err != nil || len(orders) != len(numbers) {
+		t.Errorf("GetOrders in range: got %d orders, %v", len(orders), err)
+	}
+
+	credit(t, store, "alice", "5062821234567892", 10000)
+	for _, number := range []string{"4026843483168683", "5105105105105100"} {
+		if err := store.WithdrawOrder(ctx, "alice", models.OrderLog{OrderNumber: number, Sum: 100}); err != nil {
+			t.Fatalf("WithdrawOrder(%q): %v", number, err)
+		}
+	}
+	orderLogs, err := store.GetOrderLogs(ctx, "alice", types.ListQuery{Desc: true, Limit: 1})
+	if err != nil {
+		t.Fatalf("GetOrderLogs: %v", err)
+	}
+	if len(orderLogs) != 1 || orderLogs[0].OrderNumber != "5105105105105100" {
+		t.Errorf("GetOrderLogs first page desc = %+v", orderLogs)
+	}
+	after := &types.Cursor{Time: orderLogs[0].ProcessedAt, ID: orderLogs[0].ID}
+	orderLogs, err = store.GetOrderLogs(ctx, "alice", types.ListQuery{Desc: true, Limit: 1, After: after})
+	if err != nil {
+		t.Fatalf("GetOrderLogs second page: %v", err)
+	}
+	if len(orderLogs) != 1 || orderLogs[0].OrderNumber != "4026843483168683" {
+		t.Errorf("GetOrderLogs second page desc = %+v", orderLogs)
+	}
+}
+
+func testAdjustments(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createUser(t, store, "admin")
+
+	if _, err := store.GetAdjustments(ctx, "alice"); !errors.Is(err, storage.ErrNoAdjustments) {
+		t.Errorf("GetAdjustments empty: got %v, want %v", err, storage.ErrNoAdjustments)
+	}
+	adjustment, err := store.AdjustBalance(ctx, "alice", "admin", models.Adjustment{
+		Sum: 2500, ReasonCode: "compensation", Comment: "late delivery",
+	})
+	if err != nil {
+		t.Fatalf("AdjustBalance credit: %v", err)
+	}
+	if adjustment.ID == 0 || adjustment.AdminLogin != "admin" || adjustment.AdminID == 0 {
+		t.Errorf("AdjustBalance = %+v", adjustment)
+	}
+	if _, err := store.AdjustBalance(ctx, "alice", "admin", models.Adjustment{
+		Sum: -3000, ReasonCode: "correction", Comment: "too much",
+	}); !errors.Is(err, storage.ErrNotEnoughFunds) {
+		t.Errorf("AdjustBalance over balance: got %v, want %v", err, storage.ErrNotEnoughFunds)
+	}
+	if _, err := store.AdjustBalance(ctx, "alice", "admin", models.Adjustment{
+		Sum: -500, ReasonCode: "correction", Comment: "typo",
+	}); err != nil {
+		t.Fatalf("AdjustBalance debit: %v", err)
+	}
+	if _, err := store.AdjustBalance(ctx, "bob", "admin", models.Adjustment{
+		Sum: 500, ReasonCode: "goodwill", Comment: "unknown",
+	}); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("AdjustBalance unknown user: got %v, want %v", err, storage.ErrUserNotFound)
+	}
+	checkBalance(t, store, "alice", 2000, 0)
+
+	adjustments, err := store.GetAdjustments(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetAdjustments: %v", err)
+	}
+	if len(adjustments) != 2 || adjustments[0].Sum != 2500 || adjustments[1].Sum != -500 {
+		t.Errorf("GetAdjustments = %+v", adjustments)
+	}
+}
+
+func testDispatch(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createUser(t, store, "bob")
+	for _, number := range []string{"1", "2", "3"} {
+		if err := store.CreateOrder(ctx, "bob", models.Order{Number: number, Status: "NEW"}); err != nil {
+			t.Fatalf("CreateOrder(%q): %v", number, err)
+		}
+	}
+
+	numbers, err := store.DispatchGetOrders(ctx, "NEW")
+	if err != nil {
+		t.Fatalf("DispatchGetOrders: %v", err)
+	}
+	if len(numbers) != 3 {
+		t.Errorf("DispatchGetOrders(NEW) = %v, want 3 numbers", numbers)
+	}
+
+	if err := store.DispatchUpdateOrder(ctx, models.Order{Number: "1", Status: "PROCESSED", Accrual: 50000}); err != nil {
+		t.Fatalf("DispatchUpdateOrder: %v", err)
+	}
+	if err := store.DispatchUpdateOrder(ctx, models.Order{Number: "2", Status: "INVALID"}); err != nil {
+		t.Fatalf("DispatchUpdateOrder: %v", err)
+	}
+	if numbers, _ = store.DispatchGetOrders(ctx, "NEW"); len(numbers) != 1 || numbers[0] != "3" {
+		t.Errorf("DispatchGetOrders(NEW) after update = %v, want [3]", numbers)
+	}
+	// Начисление должно попасть владельцу заказа.
+	checkBalance(t, store, "bob", 50000, 0)
+	checkBalance(t, store, "alice", 0, 0)
+
+	orders, err := store.GetOrders(ctx, "bob", types.ListQuery{})
+	if err != nil {
+		t.Fatalf("GetOrders: %v", err)
+	}
+	for _, order := range orders {
+		if order.Number == "1" && (order.Status != "PROCESSED" || order.Accrual != 50000) {
+			t.Errorf("processed order = %+v", order)
+		}
+	}
+}
+
+func testAccrualOnce(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "bob")
+	for _, number := range []string{"1", "2"} {
+		if err := store.CreateOrder(ctx, "bob", models.Order{Number: number, Status: models.OrderNew}); err != nil {
+			t.Fatalf("CreateOrder(%q): %v", number, err)
+		}
+	}
+
+	// Повторы и гонки не должны начислять баллы дважды.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	credited := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := store.DispatchUpdateOrder(ctx, models.Order{Number: "1", Status: models.OrderProcessed, Accrual: 1000})
+			if err != nil && !errors.Is(err, storage.ErrOrderFinalized) {
+				t.Errorf("DispatchUpdateOrder: %v", err)
+			}
+			if err == nil {
+				mu.Lock()
+				credited++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if credited != 1 {
+		t.Errorf("order finalized %d times, want 1", credited)
+	}
+	checkBalance(t, store, "bob", 1000, 0)
+
+	if err := store.DispatchUpdateOrder(ctx, models.Order{Number: "2", Status: models.OrderInvalid}); err != nil {
+		t.Fatalf("DispatchUpdateOrder(INVALID): %v", err)
+	}
+	if err := store.DispatchUpdateOrder(
+		ctx, models.Order{Number: "2", Status: models.OrderProcessed, Accrual: 1000},
+	); !errors.Is(err, storage.ErrOrderFinalized) {
+		t.Errorf("DispatchUpdateOrder after INVALID: got %v, want %v", err, storage.ErrOrderFinalized)
+	}
+	if err := store.DispatchUpdateOrder(
+		ctx, models.Order{Number: "404", Status: models.OrderProcessed, Accrual: 1000},
+	); !errors.Is(err, storage.ErrNoOrders) {
+		t.Errorf("DispatchUpdateOrder unknown order: got %v, want %v", err, storage.ErrNoOrders)
+	}
+	checkBalance(t, store, "bob", 1000, 0)
+	checkLedger(t, store)
+}
+
+func testClaims(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	for _, number := range []string{"1", "2", "3", "4"} {
+		if err := store.CreateOrder(ctx, "alice", models.Order{Number: number, Status: models.OrderNew}); err != nil {
+			t.Fatalf("CreateOrder(%q): %v", number, err)
+		}
+	}
+	if err := store.DispatchUpdateOrder(ctx, models.Order{Number: "4", Status: models.OrderInvalid}); err != nil {
+		t.Fatalf("DispatchUpdateOrder: %v", err)
+	}
+
+	first, err := store.DispatchClaimOrders(ctx, "first", time.Hour, 2)
+	if err != nil {
+		t.Fatalf("DispatchClaimOrders(first): %v", err)
+	}
+	second, err := store.DispatchClaimOrders(ctx, "second", time.Hour, 10)
+	if err != nil {
+		t.Fatalf("DispatchClaimOrders(second): %v", err)
+	}
+	if len(first) != 2 || len(second) != 1 {
+		t.Fatalf("claimed %v and %v, want 2 and 1 orders", first, second)
+	}
+	for _, order := range second {
+		if order.Number == first[0].Number || order.Number == first[1].Number || order.Number == "4" {
+			t.Errorf("order %s claimed twice or in final status", order.Number)
+		}
+	}
+	if again, _ := store.DispatchClaimOrders(ctx, "second", time.Hour, 10); len(again) != 1 {
+		t.Errorf("own lease renew = %v, want 1 order", again)
+	}
+
+	if active, _ := store.DispatchClaimOrders(ctx, "third", time.Hour, 10); len(active) != 0 {
+		t.Errorf("claimed %v while leases are active", active)
+	}
+
+	// Истекшую аренду может забрать другой экземпляр.
+	if _, err := store.DispatchClaimOrders(ctx, "first", -2*time.Second, 10); err != nil {
+		t.Fatalf("DispatchClaimOrders(first): %v", err)
+	}
+	if expired, _ := store.DispatchClaimOrders(ctx, "third", time.Hour, 10); len(expired) != 2 {
+		t.Errorf("claimed %v after lease expired, want 2 orders", expired)
+	}
+
+	// Продлить можно только свою аренду заказа, который еще не завершен.
+	if err := store.DispatchRenewClaim(ctx, first[0].Number, "first", time.Hour); !errors.Is(err, storage.ErrClaimLost) {
+		t.Errorf("DispatchRenewClaim of reclaimed order: got %v, want %v", err, storage.ErrClaimLost)
+	}
+	if err := store.DispatchRenewClaim(ctx, "5", "first", time.Hour); !errors.Is(err, storage.ErrClaimLost) {
+		t.Errorf("DispatchRenewClaim unknown: got %v, want %v", err, storage.ErrClaimLost)
+	}
+	number := second[0].Number
+	if _, err := store.DispatchClaimOrders(ctx, "second", -2*time.Second, 10); err != nil {
+		t.Fatalf("DispatchClaimOrders(second): %v", err)
+	}
+	if err := store.DispatchRenewClaim(ctx, number, "second", time.Hour); err != nil {
+		t.Fatalf("DispatchRenewClaim: %v", err)
+	}
+	if renewed, _ := store.DispatchClaimOrders(ctx, "fourth", time.Hour, 10); len(renewed) != 0 {
+		t.Errorf("claimed %v after lease renewed", renewed)
+	}
+	if err := store.DispatchUpdateOrder(ctx, models.Order{Number: number, Status: models.OrderInvalid}); err != nil {
+		t.Fatalf("DispatchUpdateOrder: %v", err)
+	}
+	if err := store.DispatchRenewClaim(ctx, number, "second", time.Hour); !errors.Is(err, storage.ErrClaimLost) {
+		t.Errorf("DispatchRenewClaim final: got %v, want %v", err, storage.ErrClaimLost)
+	}
+}
+
+func testDefer(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	if err := store.CreateOrder(ctx, "alice", models.Order{
+		Number: "1", Status: models.OrderNew, NextCheckAt: time.Now().Add(time.Hour).Unix(),
+	}); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if claimed, _ := store.DispatchClaimOrders(ctx, "first", time.Hour, 10); len(claimed) != 0 {
+		t.Errorf("claimed %+v before next check", claimed)
+	}
+
+	if err := store.DispatchDeferOrder(ctx, models.Order{
+		Number: "1", Status: models.OrderProcessing, NextCheckAt: time.Now().Add(-time.Second).Unix(),
+	}); err != nil {
+		t.Fatalf("DispatchDeferOrder: %v", err)
+	}
+	claimed, err := store.DispatchClaimOrders(ctx, "first", time.Hour, 10)
+	if err != nil {
+		t.Fatalf("DispatchClaimOrders: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].Status != models.OrderProcessing || claimed[0].Attempts != 0 {
+		t.Errorf("claimed %+v, want deferred order 1 without attempts", claimed)
+	}
+	if err := store.DispatchDeferOrder(ctx, models.Order{Number: "2"}); !errors.Is(err, storage.ErrNoOrders) {
+		t.Errorf("DispatchDeferOrder unknown: got %v, want %v", err, storage.ErrNoOrders)
+	}
+	if err := store.DispatchUpdateOrder(ctx, models.Order{Number: "1", Status: models.OrderInvalid}); err != nil {
+		t.Fatalf("DispatchUpdateOrder: %v", err)
+	}
+	if err := store.DispatchDeferOrder(ctx, models.Order{Number: "1"}); !errors.Is(err, storage.ErrOrderFinalized) {
+		t.Errorf("DispatchDeferOrder final: got %v, want %v", err, storage.ErrOrderFinalized)
+	}
+}
+
+func testBackoff(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	for _, number := range []string{"1", "2"} {
+		if err := store.CreateOrder(ctx, "alice", models.Order{Number: number, Status: models.OrderNew}); err != nil {
+			t.Fatalf("CreateOrder(%q): %v", number, err)
+		}
+	}
+
+	if err := store.DispatchRescheduleOrder(ctx, models.Order{
+		Number: "1", Status: models.OrderProcessing, Attempts: 1, NextCheckAt: time.Now().Add(time.Hour).Unix(),
+	}); err != nil {
+		t.Fatalf("DispatchRescheduleOrder: %v", err)
+	}
+	claimed, err := store.DispatchClaimOrders(ctx, "first", time.Hour, 10)
+	if err != nil {
+		t.Fatalf("DispatchClaimOrders: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].Number != "2" {
+		t.Errorf("claimed %+v, want only due order 2", claimed)
+	}
+	if err := store.DispatchRescheduleOrder(ctx, models.Order{
+		Number: "1", Attempts: 2, NextCheckAt: time.Now().Add(-time.Second).Unix(),
+	}); err != nil {
+		t.Fatalf("DispatchRescheduleOrder: %v", err)
+	}
+	claimed, _ = store.DispatchClaimOrders(ctx, "first", time.Hour, 10)
+	for _, order := range claimed {
+		if order.Number == "1" && (order.Attempts != 2 || order.Status != models.OrderProcessing) {
+			t.Errorf("rescheduled order = %+v", order)
+		}
+	}
+	if len(claimed) != 2 {
+		t.Errorf("claimed %+v, want 2 orders", claimed)
+	}
+
+	if _, err := store.GetStuckOrders(ctx); !errors.Is(err, storage.ErrNoOrders) {
+		t.Errorf("GetStuckOrders empty: got %v, want %v", err, storage.ErrNoOrders)
+	}
+	count, err := store.DispatchMarkStuck(ctx, time.Now().Add(time.Minute).Unix())
+	if err != nil || count != 2 {
+		t.Fatalf("DispatchMarkStuck = %d, %v, want 2", count, err)
+	}
+	if claimed, _ = store.DispatchClaimOrders(ctx, "second", time.Hour, 10); len(claimed) != 0 {
+		t.Errorf("claimed stuck orders %+v", claimed)
+	}
+	stuck, err := store.GetStuckOrders(ctx)
+	if err != nil {
+		t.Fatalf("GetStuckOrders: %v", err)
+	}
+	if len(stuck) != 2 || stuck[0].User == nil || stuck[0].User.Login != "alice" {
+		t.Errorf("GetStuckOrders = %+v", stuck)
+	}
+	if err := store.DispatchRescheduleOrder(ctx, models.Order{Number: "1", Attempts: 3}); !errors.Is(err, storage.ErrOrderFinalized) {
+		t.Errorf("DispatchRescheduleOrder stuck: got %v, want %v", err, storage.ErrOrderFinalized)
+	}
+	// Поздний ответ системы начисления по зависшему заказу принимается.
+	if err := store.DispatchUpdateOrder(ctx, models.Order{Number: "1", Status: models.OrderProcessed, Accrual: 700}); err != nil {
+		t.Fatalf("DispatchUpdateOrder stuck: %v", err)
+	}
+	checkBalance(t, store, "alice", 700, 0)
+}
+
+// checkLedger fails test if cached balances differ from ledger.
+func checkLedger(t *testing.T, store storage.Storage) {
+	t.Helper()
+	discrepancies, err := store.CheckLedger(context.Background())
+	if err != nil {
+		t.Fatalf("CheckLedger: %v", err)
+	}
+	if len(discrepancies) != 0 {
+		t.Errorf("CheckLedger = %+v, want none", discrepancies)
+	}
+}
+
+func testLedger(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createUser(t, store, "admin")
+
+	if entries, err := store.GetLedger(ctx, "alice"); err != nil || len(entries) != 0 {
+		t.Errorf("GetLedger empty = %+v, %v", entries, err)
+	}
+	credit(t, store, "alice", "12345678903", 10000)
+	if err := store.WithdrawOrder(ctx, "alice", models.OrderLog{OrderNumber: "2377225624", Sum: 3000}); err != nil {
+		t.Fatalf("WithdrawOrder: %v", err)
+	}
+	if _, err := store.AdjustBalance(ctx, "alice", "admin", models.Adjustment{
+		Sum: 500, ReasonCode: "goodwill", Comment: "sorry",
+	}); err != nil {
+		t.Fatalf("AdjustBalance: %v", err)
+	}
+	checkLedger(t, store)
+
+	entries, err := store.GetLedger(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetLedger: %v", err)
+	}
+	want := []struct {
+		kind   string
+		amount money.Amount
+	}{
+		{models.LedgerAccrual, 10000},
+		{models.LedgerWithdrawal, -3000},
+		{models.LedgerAdjustment, 500},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("GetLedger = %+v, want %d entries", entries, len(want))
+	}
+	for i, w := range want {
+		if entries[i].Transaction == nil || entries[i].Transaction.Kind != w.kind || entries[i].Amount != w.amount {
+			t.Errorf("GetLedger[%d] = %+v, want %s %s", i, entries[i], w.kind, w.amount)
+		}
+	}
+	if entries[0].Transaction.Reference != "12345678903" {
+		t.Errorf("accrual reference = %q", entries[0].Transaction.Reference)
+	}
+
+	// Отмена начисления больше остатка не должна уводить баланс в минус.
+	if _, err := store.ReverseTransaction(ctx, entries[0].TransactionID); !errors.Is(err, storage.ErrNotEnoughFunds) {
+		t.Errorf("ReverseTransaction over balance: got %v, want %v", err, storage.ErrNotEnoughFunds)
+	}
+	reversal, err := store.ReverseTransaction(ctx, entries[1].TransactionID)
+	if err != nil {
+		t.Fatalf("ReverseTransaction: %v", err)
+	}
+	if reversal.Kind != models.LedgerReversal || reversal.ReversalOf == nil || *reversal.ReversalOf != entries[1].TransactionID {
+		t.Errorf("ReverseTransaction = %+v", reversal)
+	}
+	if _, err := store.ReverseTransaction(ctx, entries[1].TransactionID); !errors.Is(err, storage.ErrAlreadyReversed) {
+		t.Errorf("ReverseTransaction twice: got %v, want %v", err, storage.ErrAlreadyReversed)
+	}
+	if _, err := store.ReverseTransaction(ctx, reversal.ID); !errors.Is(err, storage.ErrNotReversible) {
+		t.Errorf("ReverseTransaction of reversal: got %v, want %v", err, storage.ErrNotReversible)
+	}
+	if _, err := store.ReverseTransaction(ctx, 1<<30); !errors.Is(err, storage.ErrTransactionNotFound) {
+		t.Errorf("ReverseTransaction unknown: got %v, want %v", err, storage.ErrTransactionNotFound)
+	}
+	// Отмененное списание не входит в сумму списаний, заказ можно
+	// оплатить снова.
+	checkBalance(t, store, "alice", 10500, 0)
+	if err := store.WithdrawOrder(ctx, "alice", models.OrderLog{OrderNumber: "2377225624", Sum: 1000}); err != nil {
+		t.Fatalf("WithdrawOrder after reversal: %v", err)
+	}
+	if err := store.WithdrawOrder(ctx, "alice", models.OrderLog{OrderNumber: "2377225624", Sum: 1000}); !errors.Is(err, storage.ErrWithdrawalExists) {
+		t.Errorf("WithdrawOrder twice after reversal: got %v, want %v", err, storage.ErrWithdrawalExists)
+	}
+	if orderLogs, err := store.GetOrderLogs(ctx, "alice", types.ListQuery{}); err != nil || len(orderLogs) != 2 {
+		t.Errorf("GetOrderLogs after reversal = %+v, %v, want 2 withdrawals", orderLogs, err)
+	}
+	checkBalance(t, store, "alice", 9500, 1000)
+	checkLedger(t, store)
+}
+
+func testSessions(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	expires := time.Now().Add(time.Hour).Unix()
+
+	first, err := store.CreateSession(ctx, "alice", models.Session{RefreshHash: "h1", UserAgent: "curl", ExpiresAt: expires})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	second, err := store.CreateSession(ctx, "alice", models.Session{RefreshHash: "h2", ExpiresAt: expires})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if _, err := store.CreateSession(ctx, "alice", models.Session{RefreshHash: "h3", ExpiresAt: time.Now().Add(-time.Hour).Unix()}); err != nil {
+		t.Fatalf("CreateSession expired: %v", err)
+	}
+	if session, err := store.GetSession(ctx, first.ID); err != nil || session.UserAgent != "curl" {
+		t.Errorf("GetSession = %+v, %v", session, err)
+	}
+
+	user, session, err := store.RefreshSession(ctx, "h1", models.Session{RefreshHash: "h1-next", ExpiresAt: expires})
+	if err != nil {
+		t.Fatalf("RefreshSession: %v", err)
+	}
+	if user.Login != "alice" || session.ID != first.ID {
+		t.Errorf("RefreshSession = %+v, %+v", user, session)
+	}
+	if _, _, err := store.RefreshSession(ctx, "h1-next", models.Session{RefreshHash: "h1-last", ExpiresAt: expires}); err != nil {
+		t.Fatalf("RefreshSession rotated: %v", err)
+	}
+	// Повтор замененного токена значит, что он украден: сессия отзывается
+	// вместе с последним выданным в ней токеном.
+	if _, _, err := store.RefreshSession(ctx, "h1", models.Session{RefreshHash: "h1-other", ExpiresAt: expires}); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Errorf("RefreshSession reuse: got %v, want %v", err, storage.ErrInvalidRefreshToken)
+	}
+	if _, err := store.GetSession(ctx, first.ID); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("GetSession after reuse: got %v, want %v", err, storage.ErrSessionNotFound)
+	}
+	if _, _, err := store.RefreshSession(ctx, "h1-last", models.Session{RefreshHash: "h1-after", ExpiresAt: expires}); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Errorf("RefreshSession after reuse: got %v, want %v", err, storage.ErrInvalidRefreshToken)
+	}
+	if _, _, err := store.RefreshSession(ctx, "h3", models.Session{RefreshHash: "h3-next", ExpiresAt: expires}); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Errorf("RefreshSession expired: got %v, want %v", err, storage.ErrInvalidRefreshToken)
+	}
+
+	sessions, err := store.GetSessions(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetSessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != second.ID {
+		t.Errorf("GetSessions = %+v, want only session %d", sessions, second.ID)
+	}
+
+	if err := store.RevokeSession(ctx, "alice", second.ID); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if err := store.RevokeSession(ctx, "alice", second.ID); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("RevokeSession twice: got %v, want %v", err, storage.ErrSessionNotFound)
+	}
+	if _, err := store.GetSession(ctx, second.ID); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("GetSession revoked: got %v, want %v", err, storage.ErrSessionNotFound)
+	}
+	if _, _, err := store.RefreshSession(ctx, "h2", models.Session{RefreshHash: "h2-next", ExpiresAt: expires}); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Errorf("RefreshSession revoked: got %v, want %v", err, storage.ErrInvalidRefreshToken)
+	}
+
+	third, err := store.CreateSession(ctx, "alice", models.Session{RefreshHash: "h4", ExpiresAt: expires})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err := store.RevokeSessions(ctx, "alice", third.ID); err != nil {
+		t.Fatalf("RevokeSessions: %v", err)
+	}
+	if sessions, _ = store.GetSessions(ctx, "alice"); len(sessions) != 1 || sessions[0].ID != third.ID {
+		t.Errorf("GetSessions after RevokeSessions = %+v", sessions)
+	}
+}
+
+func testConcurrentWithdraw(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	credit(t, store, "alice", "12345678903", 1000)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := store.WithdrawOrder(ctx, "alice", models.OrderLog{OrderNumber: fmt.Sprint(i), Sum: 100})
+			if err != nil && !errors.Is(err, storage.ErrNotEnoughFunds) {
+				t.Errorf("WithdrawOrder: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	checkBalance(t, store, "alice", 0, 1000)
+	checkLedger(t, store)
+}
+
+func testIdempotency(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createUser(t, store, "bob")
+	now := time.Now().Unix()
+
+	first, err := store.CreateIdempotencyKey(ctx, "alice", models.IdempotencyKey{Key: "k1", RequestHash: "h1"}, now-3600)
+	if err != nil {
+		t.Fatalf("CreateIdempotencyKey: %v", err)
+	}
+	if first.ID == 0 || first.Status != 0 || first.CreatedAt == 0 {
+		t.Errorf("CreateIdempotencyKey = %+v", first)
+	}
+	if _, err := store.CreateIdempotencyKey(ctx, "bob", models.IdempotencyKey{Key: "k1", RequestHash: "h2"}, now-3600); err != nil {
+		t.Errorf("CreateIdempotencyKey another user: %v", err)
+	}
+
+	saved, err := store.CreateIdempotencyKey(ctx, "alice", models.IdempotencyKey{Key: "k1", RequestHash: "h2"}, now-3600)
+	if !errors.Is(err, storage.ErrIdempotencyKeyExists) {
+		t.Fatalf("CreateIdempotencyKey in progress: got %v, want %v", err, storage.ErrIdempotencyKeyExists)
+	}
+	if saved.ID != first.ID || saved.RequestHash != "h1" || saved.Status != 0 {
+		t.Errorf("CreateIdempotencyKey in progress = %+v", saved)
+	}
+
+	first.Status = 200
+	first.ContentType = "application/json"
+	first.Body = []byte(`{"ok":true}`)
+	if err := store.CompleteIdempotencyKey(ctx, *first); err != nil {
+		t.Fatalf("CompleteIdempotencyKey: %v", err)
+	}
+	saved, err = store.CreateIdempotencyKey(ctx, "alice", models.IdempotencyKey{Key: "k1", RequestHash: "h1"}, now-3600)
+	if !errors.Is(err, storage.ErrIdempotencyKeyExists) {
+		t.Fatalf("CreateIdempotencyKey completed: got %v, want %v", err, storage.ErrIdempotencyKeyExists)
+	}
+	if saved.Status != 200 || saved.ContentType != "application/json" || string(saved.Body) != `{"ok":true}` {
+		t.Errorf("CreateIdempotencyKey completed = %+v", saved)
+	}
+
+	if err := store.DeleteIdempotencyKey(ctx, first.ID); err != nil {
+		t.Fatalf("DeleteIdempotencyKey: %v", err)
+	}
+	second, err := store.CreateIdempotencyKey(ctx, "alice", models.IdempotencyKey{Key: "k1", RequestHash: "h3"}, now-3600)
+	if err != nil {
+		t.Fatalf("CreateIdempotencyKey after delete: %v", err)
+	}
+	if second.RequestHash != "h3" {
+		t.Errorf("CreateIdempotencyKey after delete = %+v", second)
+	}
+
+	// Ключи, созданные раньше expiredBefore, забыты.
+	if _, err := store.CreateIdempotencyKey(ctx, "alice", models.IdempotencyKey{Key: "k1", RequestHash: "h4"}, now+1); err != nil {
+		t.Errorf("CreateIdempotencyKey expired: %v", err)
+	}
+	if _, err := store.CreateIdempotencyKey(ctx, "carol", models.IdempotencyKey{Key: "k1", RequestHash: "h1"}, now); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("CreateIdempotencyKey unknown user: got %v, want %v", err, storage.ErrUserNotFound)
+	}
+}
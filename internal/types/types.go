@@ -1,36 +1,218 @@
 package types
 
-import "github.com/golang-jwt/jwt/v4"
+import (
+	"encoding/json"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/hrapovd1/loyalty-account/internal/money"
+)
 
 type Claims struct {
-	Login string `json:"login"`
+	Login     string `json:"login"`
+	SessionID uint   `json:"sid"`
+	Role      string `json:"role"`
 	jwt.RegisteredClaims
 }
 
 type LoginResponse struct {
-	Authtoken string `json:"auth_token"`
+	Authtoken    string `json:"auth_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// ClientInfo describes device which opens session.
+type ClientInfo struct {
+	UserAgent string
+	IPAddress string
+}
+
+type SessionResponse struct {
+	ID         uint   `json:"id"`
+	UserAgent  string `json:"user_agent"`
+	IPAddress  string `json:"ip_address"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at"`
+	ExpiresAt  string `json:"expires_at"`
+	Current    bool   `json:"current"`
 }
 
 type OrderResponse struct {
-	Number     string  `json:"number"`
-	Status     string  `json:"status"`
-	Accrual    float64 `json:"accrual,omitempty"`
-	UploadedAt string  `json:"uploaded_at"`
+	Number     string       `json:"number"`
+	Status     string       `json:"status"`
+	Accrual    money.Amount `json:"accrual,omitempty"`
+	UploadedAt string       `json:"uploaded_at"`
+}
+
+type StuckOrderResponse struct {
+	Number     string `json:"number"`
+	Login      string `json:"login"`
+	Attempts   int    `json:"attempts"`
+	UploadedAt string `json:"uploaded_at"`
+}
+
+// WithdrawRequest is body of withdrawal, time of withdrawal is set
+// by server.
+type WithdrawRequest struct {
+	Order string       `json:"order"`
+	Sum   money.Amount `json:"sum"`
 }
 
 type OrderLogResponse struct {
-	OrderNumber string  `json:"order"`
-	Sum         float64 `json:"sum"`
-	ProcessedAt string  `json:"processed_at"`
+	OrderNumber string       `json:"order"`
+	Sum         money.Amount `json:"sum"`
+	ProcessedAt string       `json:"processed_at"`
+}
+
+type UserResponse struct {
+	Login   string  `json:"login"`
+	Role    string  `json:"role"`
+	Balance Balance `json:"balance"`
+}
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+type AdjustmentRequest struct {
+	Sum     money.Amount `json:"sum"`
+	Reason  string       `json:"reason"`
+	Comment string       `json:"comment"`
+}
+
+type AdjustmentResponse struct {
+	ID        uint         `json:"id"`
+	Sum       money.Amount `json:"sum"`
+	Reason    string       `json:"reason"`
+	Comment   string       `json:"comment"`
+	Admin     string       `json:"admin,omitempty"`
+	CreatedAt string       `json:"created_at"`
+}
+
+type LedgerEntryResponse struct {
+	TransactionID uint         `json:"transaction_id"`
+	Kind          string       `json:"kind"`
+	Reference     string       `json:"reference,omitempty"`
+	ReversalOf    *uint        `json:"reversal_of,omitempty"`
+	Amount        money.Amount `json:"amount"`
+	CreatedAt     string       `json:"created_at"`
+}
+
+type LedgerTransactionResponse struct {
+	ID         uint   `json:"id"`
+	Kind       string `json:"kind"`
+	Reference  string `json:"reference,omitempty"`
+	ReversalOf *uint  `json:"reversal_of,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// LedgerDiscrepancy is a ledger consistency problem, either account
+// whose cached balance differs from sum of its entries or transaction
+// whose entries do not sum to zero.
+type LedgerDiscrepancy struct {
+	AccountID     uint
+	Account       string
+	TransactionID uint
+	Expected      money.Amount
+	Actual        money.Amount
+}
+
+// CheckResult is result of named readiness check, Status is "ok" or
+// "fail". Err of failed check may reveal addresses of dependencies, so
+// it is not sent to client.
+type CheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Err    error  `json:"-"`
+}
+
+type ReadinessResponse struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
 }
 
 type Balance struct {
-	Balance float64 `json:"current"`
-	Summ    float64 `json:"withdrawn"`
+	Balance money.Amount `json:"current"`
+	Summ    money.Amount `json:"withdrawn"`
 }
 
 type AccrualAnswer struct {
-	OrderNumber string  `json:"order"`
-	Status      string  `json:"status"`
-	Accrual     float64 `json:"accrual"`
+	OrderNumber string       `json:"order"`
+	Status      string       `json:"status"`
+	Accrual     money.Amount `json:"accrual"`
+}
+
+// UnmarshalJSON accepts accrual of any precision and rounds it to
+// hundredths by money.Round, so percentage rewards like 3317.9993 are
+// credited as 3318.
+func (a *AccrualAnswer) UnmarshalJSON(data []byte) error {
+	type answer AccrualAnswer
+	var raw struct {
+		answer
+		Accrual *json.Number `json:"accrual"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*a = AccrualAnswer(raw.answer)
+	a.Accrual = 0
+	if raw.Accrual != nil {
+		accrual, err := money.Round(raw.Accrual.String())
+		if err != nil {
+			return err
+		}
+		a.Accrual = accrual
+	}
+	return nil
+}
+
+type JWK struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+	Curve   string `json:"crv,omitempty"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+	X       string `json:"x,omitempty"`
+	Y       string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ListQuery selects page of user orders or withdrawals ordered by time
+// and id. From and To limit time as [From, To), zero is no limit.
+// Statuses filter orders, empty is any status. Page starts after
+// cursor After, Limit 0 is no limit.
+type ListQuery struct {
+	Statuses []string
+	From     int64
+	To       int64
+	Desc     bool
+	After    *Cursor
+	Limit    int
+}
+
+// Cursor is position of record in list, time and id of the last record
+// of previous page.
+type Cursor struct {
+	Time int64
+	ID   uint
+}
+
+// Problem is error response in format of RFC 7807, Code is stable
+// machine-readable code of error.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
 }
@@ -0,0 +1,48 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hrapovd1/loyalty-account/internal/money"
+)
+
+func TestAccrualAnswerUnmarshal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want AccrualAnswer
+	}{
+		{`{"order":"1","status":"PROCESSED","accrual":729.98}`, AccrualAnswer{"1", "PROCESSED", 72998}},
+		{`{"order":"1","status":"PROCESSED","accrual":3317.9993}`, AccrualAnswer{"1", "PROCESSED", 331800}},
+		{`{"order":"1","status":"PROCESSED","accrual":"0.005"}`, AccrualAnswer{"1", "PROCESSED", 1}},
+		{`{"order":"1","status":"PROCESSING"}`, AccrualAnswer{"1", "PROCESSING", 0}},
+		{`{"order":"1","status":"INVALID","accrual":null}`, AccrualAnswer{"1", "INVALID", 0}},
+	}
+	for _, tt := range tests {
+		var got AccrualAnswer
+		if err := json.Unmarshal([]byte(tt.in), &got); err != nil {
+			t.Errorf("Unmarshal(%s) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Unmarshal(%s) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		`{"order":"1","status":"PROCESSED","accrual":"abc"}`,
+		`{"order":"1","status":"PROCESSED","accrual":1e999999}`,
+		`{"order":"1","status":"PROCESSED","accrual":true}`,
+	} {
+		var got AccrualAnswer
+		if err := json.Unmarshal([]byte(in), &got); err == nil {
+			t.Errorf("Unmarshal(%s) error = nil, answer %+v", in, got)
+		}
+	}
+
+	// Ответ фиктивной системы начисления кодируется как есть.
+	data, err := json.Marshal(AccrualAnswer{OrderNumber: "1", Status: "PROCESSED", Accrual: money.Amount(72998)})
+	if err != nil || string(data) != `{"order":"1","status":"PROCESSED","accrual":729.98}` {
+		t.Errorf("Marshal = %s, %v", data, err)
+	}
+}
@@ -2,30 +2,67 @@ package usecase
 
 import (
 	"context"
+	"errors"
 	"strconv"
 	"time"
 
-	"github.com/hrapovd1/loyalty-account/internal/dbstorage"
+	"github.com/hrapovd1/loyalty-account/internal/metrics"
 	"github.com/hrapovd1/loyalty-account/internal/models"
+	"github.com/hrapovd1/loyalty-account/internal/storage"
 	"github.com/hrapovd1/loyalty-account/internal/types"
 )
 
-func SaveOrder(ctx context.Context, storage *dbstorage.DBStorage, login string, number string) error {
+var ErrUnknownAccrualStatus = errors.New("unknown accrual status")
+
+// SaveOrder saves new order of user, not zero pushTimeout postpones first
+// check of order by dispatcher while waiting push from accrual system.
+func SaveOrder(ctx context.Context, store storage.Storage, login string, number string, pushTimeout time.Duration) error {
 	select {
 	case <-ctx.Done():
 		return nil
 	default:
 		order := models.Order{
 			Number: number,
-			Status: "NEW",
+			Status: models.OrderNew,
+		}
+		if pushTimeout > 0 {
+			order.NextCheckAt = time.Now().Add(pushTimeout).Unix()
 		}
-		if err := storage.CreateOrder(ctx, login, order); err != nil {
+		if err := store.CreateOrder(ctx, login, order); err != nil {
 			return err
 		}
 		return nil
 	}
 }
 
+// ApplyAccrual saves status of order received from accrual system by
+// dispatcher or webhook. Final status is saved once, intermediate one
+// postpones polling of order for pushTimeout.
+func ApplyAccrual(ctx context.Context, store storage.Storage, answer types.AccrualAnswer, pushTimeout time.Duration) error {
+	switch answer.Status {
+	case models.OrderInvalid, models.OrderProcessed:
+		if err := store.DispatchUpdateOrder(ctx, models.Order{
+			Number:  answer.OrderNumber,
+			Status:  answer.Status,
+			Accrual: answer.Accrual,
+		}); err != nil {
+			return err
+		}
+		if answer.Status == models.OrderProcessed {
+			metrics.AccrualCredited.Add(answer.Accrual.Float64())
+		}
+		return nil
+	case models.OrderRegistered, models.OrderProcessing:
+		return store.DispatchDeferOrder(ctx, models.Order{
+			Number:      answer.OrderNumber,
+			Status:      models.OrderProcessing,
+			NextCheckAt: time.Now().Add(pushTimeout).Unix(),
+		})
+	default:
+		return ErrUnknownAccrualStatus
+	}
+}
+
 func OrdersTimeFormat(orders []models.Order) []types.OrderResponse {
 	orderResp := make([]types.OrderResponse, 0)
 	for _, order := range orders {
@@ -34,6 +71,10 @@ func OrdersTimeFormat(orders []models.Order) []types.OrderResponse {
 			Status:     order.Status,
 			UploadedAt: time.Unix(order.UploadedAt, 0).Format(time.RFC3339),
 		}
+		// Для пользователя зависший заказ все еще обрабатывается.
+		if order.Status == models.OrderStuck {
+			resp.Status = models.OrderProcessing
+		}
 		if order.Accrual > 0 {
 			resp.Accrual = order.Accrual
 		}
@@ -43,6 +84,23 @@ func OrdersTimeFormat(orders []models.Order) []types.OrderResponse {
 	return orderResp
 }
 
+func StuckOrdersTimeFormat(orders []models.Order) []types.StuckOrderResponse {
+	orderResp := make([]types.StuckOrderResponse, 0)
+	for _, order := range orders {
+		resp := types.StuckOrderResponse{
+			Number:     order.Number,
+			Attempts:   order.Attempts,
+			UploadedAt: time.Unix(order.UploadedAt, 0).Format(time.RFC3339),
+		}
+		if order.User != nil {
+			resp.Login = order.User.Login
+		}
+		orderResp = append(orderResp, resp)
+	}
+
+	return orderResp
+}
+
 func OrderLogsTimeFormat(orders []models.OrderLog) []types.OrderLogResponse {
 	orderLogResp := make([]types.OrderLogResponse, 0)
 	for _, order := range orders {
@@ -56,6 +114,72 @@ func OrderLogsTimeFormat(orders []models.OrderLog) []types.OrderLogResponse {
 	return orderLogResp
 }
 
+// AdjustmentReasons are allowed reason codes of manual balance adjustments.
+var AdjustmentReasons = map[string]bool{
+	"compensation": true,
+	"goodwill":     true,
+	"correction":   true,
+	"fraud":        true,
+	"other":        true,
+}
+
+// AdjustmentsTimeFormat converts adjustments to response, admin login is
+// shown only to support staff.
+func AdjustmentsTimeFormat(adjustments []models.Adjustment, withAdmin bool) []types.AdjustmentResponse {
+	adjustmentResp := make([]types.AdjustmentResponse, 0)
+	for _, adjustment := range adjustments {
+		resp := types.AdjustmentResponse{
+			ID:        adjustment.ID,
+			Sum:       adjustment.Sum,
+			Reason:    adjustment.ReasonCode,
+			Comment:   adjustment.Comment,
+			CreatedAt: time.Unix(adjustment.CreatedAt, 0).Format(time.RFC3339),
+		}
+		if withAdmin {
+			resp.Admin = adjustment.AdminLogin
+		}
+		adjustmentResp = append(adjustmentResp, resp)
+	}
+
+	return adjustmentResp
+}
+
+func LedgerTimeFormat(entries []models.LedgerEntry) []types.LedgerEntryResponse {
+	ledgerResp := make([]types.LedgerEntryResponse, 0)
+	for _, entry := range entries {
+		resp := types.LedgerEntryResponse{
+			TransactionID: entry.TransactionID,
+			Amount:        entry.Amount,
+		}
+		if entry.Transaction != nil {
+			resp.Kind = entry.Transaction.Kind
+			resp.Reference = entry.Transaction.Reference
+			resp.ReversalOf = entry.Transaction.ReversalOf
+			resp.CreatedAt = time.Unix(entry.Transaction.CreatedAt, 0).Format(time.RFC3339)
+		}
+		ledgerResp = append(ledgerResp, resp)
+	}
+
+	return ledgerResp
+}
+
+func SessionsTimeFormat(sessions []models.Session, currentID uint) []types.SessionResponse {
+	sessionResp := make([]types.SessionResponse, 0)
+	for _, session := range sessions {
+		sessionResp = append(sessionResp, types.SessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			CreatedAt:  time.Unix(session.CreatedAt, 0).Format(time.RFC3339),
+			LastUsedAt: time.Unix(session.LastUsedAt, 0).Format(time.RFC3339),
+			ExpiresAt:  time.Unix(session.ExpiresAt, 0).Format(time.RFC3339),
+			Current:    session.ID == currentID,
+		})
+	}
+
+	return sessionResp
+}
+
 // Func check number according Luhn algorithm
 // https://ru.wikipedia.org/wiki/Алгоритм_Луна
 func IsOrderNumValid(number string) bool {